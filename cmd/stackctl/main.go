@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/example/stackctl/internal/stackctl"
 	"github.com/example/stackctl/internal/tui"
 )
 
 func main() {
-	args := os.Args[1:]
+	args, verbose := extractVerboseFlag(os.Args[1:])
 
 	if len(args) > 0 {
 		env := extractEnvFlag(args[1:])
@@ -33,6 +37,10 @@ func main() {
 			}
 			return
 		case "config":
+			// config track, log and revert are plain CLI commands.
+			if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+				break
+			}
 			if err := tui.StartConfigWizard(env); err != nil {
 				fmt.Fprintf(os.Stderr, "error: %v\n", err)
 				os.Exit(1)
@@ -41,12 +49,41 @@ func main() {
 		}
 	}
 
-	if err := stackctl.Run(args); err != nil {
+	// Ctrl-C or a stop from systemd interrupts the running commands and lets
+	// the run record itself and release its lock. A second Ctrl-C kills.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if verbose {
+		ctx = stackctl.WithRunner(ctx, &stackctl.VerboseRunner{Runner: stackctl.RunnerFrom(ctx), Log: os.Stderr})
+	}
+
+	if err := stackctl.Run(ctx, args); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "error: interrupted: %v\n", err)
+			os.Exit(130)
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// extractVerboseFlag removes --verbose, which any command takes, from args.
+func extractVerboseFlag(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	verbose := false
+	for _, arg := range args {
+		if arg == "--verbose" {
+			verbose = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, verbose
+}
+
 func extractEnvFlag(args []string) string {
 	for i, arg := range args {
 		if arg == "--env" && i+1 < len(args) {
@@ -0,0 +1,150 @@
+// Package engine is a small client for the Docker Engine API. It covers what
+// stackctl reads often enough that running the docker CLI for it is too
+// slow: the containers of a compose project, their stats and their logs.
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// APIVersion is the Engine API version of every request. Docker 20.10
+	// and later serve it.
+	APIVersion = "1.41"
+	// DefaultHost is the local engine's socket.
+	DefaultHost = "unix:///var/run/docker.sock"
+)
+
+// Client talks to one Docker Engine, over its unix socket or over TCP such as
+// a socket proxy.
+type Client struct {
+	host string
+	base string
+	http *http.Client
+}
+
+// New returns a client for host, given the way DOCKER_HOST is:
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+func New(host string) (*Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("docker host %q has no socket path", host)
+		}
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		// The host part of the URLs is ignored by the dialer.
+		return &Client{host: host, base: "http://docker", http: &http.Client{Transport: transport}}, nil
+	case "tcp", "http":
+		if u.Host == "" {
+			return nil, fmt.Errorf("docker host %q has no address", host)
+		}
+		return &Client{host: host, base: "http://" + u.Host, http: &http.Client{Transport: &http.Transport{}}}, nil
+	}
+	return nil, fmt.Errorf("docker host %q: only unix:// and tcp:// are supported", host)
+}
+
+// FromEnv returns a client for DOCKER_HOST, or for the local socket when it
+// is not set.
+func FromEnv() (*Client, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return New(host)
+	}
+	return New(DefaultHost)
+}
+
+// Host is the address the client was created for.
+func (c *Client) Host() string {
+	return c.host
+}
+
+// Error is an answer of the engine with an error status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker engine: %s (%d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is the engine saying the object does not
+// exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Ping checks that the engine answers.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.get(ctx, "/_ping", nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// get sends a GET for path and returns the response of a successful one; the
+// caller closes its body.
+func (c *Client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	target := c.base + "/v" + APIVersion + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL is made up for the unix socket; the host says more.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("docker engine at %s: %w", c.host, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(b))
+	}
+	return nil, &Error{StatusCode: resp.StatusCode, Message: body.Message}
+}
+
+// getJSON sends a GET for path and decodes the answer into v.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("docker engine %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestClient serves handler on a unix socket and returns a client for it.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	c, err := New("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListContainersFilters(t *testing.T) {
+	var gotPath, gotAll string
+	var gotFilters map[string][]string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAll = r.URL.Query().Get("all")
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &gotFilters); err != nil {
+			t.Errorf("filters %q: %v", r.URL.Query().Get("filters"), err)
+		}
+		writeJSON(t, w, []Container{
+			{ID: "3", Names: []string{"/dev-web-2"}, Labels: map[string]string{ServiceLabel: "web"}},
+			{ID: "1", Names: []string{"/dev-db-1"}, Labels: map[string]string{ServiceLabel: "db"}},
+			{ID: "2", Names: []string{"/dev-web-1"}, Labels: map[string]string{ServiceLabel: "web"}},
+		})
+	})
+
+	containers, err := c.ListContainers(context.Background(), ListOptions{Project: "dev", Service: "web", All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/v"+APIVersion+"/containers/json" {
+		t.Errorf("path = %q", gotPath)
+	}
+	if gotAll != "1" {
+		t.Errorf("all = %q, want 1", gotAll)
+	}
+	want := map[string][]string{"label": {ProjectLabel + "=dev", ServiceLabel + "=web"}}
+	if !reflect.DeepEqual(gotFilters, want) {
+		t.Errorf("filters = %v, want %v", gotFilters, want)
+	}
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Name())
+	}
+	if got := strings.Join(names, " "); got != "dev-db-1 dev-web-1 dev-web-2" {
+		t.Errorf("containers = %s, want them sorted by service and name", got)
+	}
+}
+
+func TestListContainersNoOptions(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.RawQuery; q != "" {
+			t.Errorf("query = %q, want none", q)
+		}
+		writeJSON(t, w, []Container{})
+	})
+	if _, err := c.ListContainers(context.Background(), ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerStats(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v"+APIVersion+"/containers/abc/stats" || r.URL.Query().Get("stream") != "false" {
+			t.Errorf("request = %s", r.URL)
+		}
+		w.Write([]byte(`{
+			"cpu_stats": {"cpu_usage": {"total_usage": 400}, "system_cpu_usage": 2000, "online_cpus": 2},
+			"precpu_stats": {"cpu_usage": {"total_usage": 200}, "system_cpu_usage": 1000},
+			"memory_stats": {"usage": 1000, "limit": 1600, "stats": {"inactive_file": 200}}
+		}`))
+	})
+	got, err := c.ContainerStats(context.Background(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{CPUPercent: 40, MemUsage: 800, MemLimit: 1600, MemPercent: 50}
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsCalculation(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Stats
+	}{
+		{
+			name: "per-cpu usage when online_cpus is missing",
+			json: `{"cpu_stats": {"cpu_usage": {"total_usage": 300, "percpu_usage": [1, 2, 3, 4]}, "system_cpu_usage": 1100},
+				"precpu_stats": {"cpu_usage": {"total_usage": 100}, "system_cpu_usage": 100}}`,
+			want: Stats{CPUPercent: 80},
+		},
+		{
+			name: "first sample has no previous reading",
+			json: `{"cpu_stats": {"cpu_usage": {"total_usage": 300}, "system_cpu_usage": 1100, "online_cpus": 1},
+				"precpu_stats": {"cpu_usage": {"total_usage": 300}, "system_cpu_usage": 1100}}`,
+			want: Stats{},
+		},
+		{
+			name: "cgroup v1 cache",
+			json: `{"memory_stats": {"usage": 1000, "limit": 2000, "stats": {"total_inactive_file": 500, "inactive_file": 100}}}`,
+			want: Stats{MemUsage: 500, MemLimit: 2000, MemPercent: 25},
+		},
+		{
+			name: "cache larger than usage",
+			json: `{"memory_stats": {"usage": 100, "stats": {"inactive_file": 500}}}`,
+			want: Stats{MemUsage: 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s statsJSON
+			if err := json.Unmarshal([]byte(tt.json), &s); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.stats(); got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// frame returns a frame of a multiplexed log stream.
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestDemux(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(frame(1, "out 1\n"))
+	in.Write(frame(2, "err 1\n"))
+	in.Write(frame(1, ""))
+	in.Write(frame(1, "out 2\n"))
+
+	var stdout, stderr bytes.Buffer
+	if err := demux(&in, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "out 1\nout 2\n" {
+		t.Errorf("stdout = %q", got)
+	}
+	if got := stderr.String(); got != "err 1\n" {
+		t.Errorf("stderr = %q", got)
+	}
+}
+
+func TestDemuxTruncated(t *testing.T) {
+	f := frame(1, "hello")
+	var stdout, stderr bytes.Buffer
+	if err := demux(bytes.NewReader(f[:len(f)-2]), &stdout, &stderr); err == nil {
+		t.Error("truncated payload: want an error")
+	}
+	if err := demux(bytes.NewReader(f[:4]), &stdout, &stderr); err == nil {
+		t.Error("truncated header: want an error")
+	}
+}
+
+func TestLogs(t *testing.T) {
+	for _, tty := range []bool{false, true} {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v" + APIVersion + "/containers/abc/json":
+				w.Write([]byte(`{"Config": {"Tty": ` + strconv.FormatBool(tty) + `}}`))
+			case "/v" + APIVersion + "/containers/abc/logs":
+				if q := r.URL.Query(); q.Get("tail") != "5" || q.Get("follow") != "" {
+					t.Errorf("query = %s", r.URL.RawQuery)
+				}
+				if tty {
+					w.Write([]byte("plain\n"))
+					return
+				}
+				w.Write(frame(1, "out\n"))
+				w.Write(frame(2, "err\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		})
+		var stdout, stderr bytes.Buffer
+		if err := c.Logs(context.Background(), "abc", LogsOptions{Tail: 5}, &stdout, &stderr); err != nil {
+			t.Fatalf("tty %v: %v", tty, err)
+		}
+		wantOut, wantErr := "out\n", "err\n"
+		if tty {
+			wantOut, wantErr = "plain\n", ""
+		}
+		if stdout.String() != wantOut || stderr.String() != wantErr {
+			t.Errorf("tty %v: stdout %q stderr %q, want %q and %q", tty, stdout.String(), stderr.String(), wantOut, wantErr)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v" + APIVersion + "/containers/missing/json":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "No such container: missing"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("  something broke\n"))
+		}
+	})
+
+	err := c.Logs(context.Background(), "missing", LogsOptions{}, &bytes.Buffer{}, &bytes.Buffer{})
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusNotFound || e.Message != "No such container: missing" {
+		t.Fatalf("err = %#v, want a 404 *Error with the engine's message", err)
+	}
+	if !IsNotFound(err) {
+		t.Error("IsNotFound = false for a 404")
+	}
+	if got, want := err.Error(), "docker engine: No such container: missing (404)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	_, err = c.ListContainers(context.Background(), ListOptions{})
+	if !errors.As(err, &e) || e.StatusCode != http.StatusInternalServerError || e.Message != "something broke" {
+		t.Fatalf("err = %#v, want a 500 *Error with the plain text body", err)
+	}
+	if IsNotFound(err) {
+		t.Error("IsNotFound = true for a 500")
+	}
+	if IsNotFound(errors.New("not found")) {
+		t.Error("IsNotFound = true for an error that is not the engine's")
+	}
+}
+
+func TestNewHost(t *testing.T) {
+	for host, ok := range map[string]bool{
+		"unix:///var/run/docker.sock": true,
+		"tcp://127.0.0.1:2375":        true,
+		"unix://":                     false,
+		"tcp://":                      false,
+		"ssh://host":                  false,
+	} {
+		if _, err := New(host); (err == nil) != ok {
+			t.Errorf("New(%q) error = %v", host, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Labels docker compose puts on the containers it creates.
+const (
+	ProjectLabel = "com.docker.compose.project"
+	ServiceLabel = "com.docker.compose.service"
+)
+
+// Container is an entry of the engine's container list.
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []Port            `json:"Ports"`
+}
+
+// Port is a port of a container, published on the host if PublicPort is set.
+type Port struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+// Name is the container's name without the leading slash.
+func (c Container) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// Service is the compose service the container belongs to.
+func (c Container) Service() string {
+	return c.Labels[ServiceLabel]
+}
+
+// Health is healthy, unhealthy or starting for a container with a
+// healthcheck and empty otherwise. The list only has it in Status.
+func (c Container) Health() string {
+	switch {
+	case strings.Contains(c.Status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(c.Status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(c.Status, "(health: starting)"):
+		return "starting"
+	}
+	return ""
+}
+
+// PortsString formats the ports the way docker ps does.
+func (c Container) PortsString() string {
+	var ports []string
+	for _, p := range c.Ports {
+		if p.PublicPort == 0 {
+			ports = append(ports, fmt.Sprintf("%d/%s", p.PrivatePort, p.Type))
+			continue
+		}
+		ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+	}
+	return strings.Join(ports, ", ")
+}
+
+// ListOptions selects containers; zero fields match everything.
+type ListOptions struct {
+	// Project is the compose project, stackctl's environment name.
+	Project string
+	Service string
+	// All includes containers that are not running.
+	All bool
+}
+
+// ListContainers returns the containers matching opts, sorted by service and
+// name.
+func (c *Client) ListContainers(ctx context.Context, opts ListOptions) ([]Container, error) {
+	query := url.Values{}
+	if opts.All {
+		query.Set("all", "1")
+	}
+	var labels []string
+	if opts.Project != "" {
+		labels = append(labels, ProjectLabel+"="+opts.Project)
+	}
+	if opts.Service != "" {
+		labels = append(labels, ServiceLabel+"="+opts.Service)
+	}
+	if len(labels) > 0 {
+		filters, err := json.Marshal(map[string][]string{"label": labels})
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(filters))
+	}
+
+	var containers []Container
+	if err := c.getJSON(ctx, "/containers/json", query, &containers); err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Service() != containers[j].Service() {
+			return containers[i].Service() < containers[j].Service()
+		}
+		return containers[i].Name() < containers[j].Name()
+	})
+	return containers, nil
+}
+
+// Stats is one sample of a container's resource usage, computed the way
+// docker stats does.
+type Stats struct {
+	CPUPercent float64
+	MemUsage   uint64
+	MemLimit   uint64
+	MemPercent float64
+}
+
+type statsJSON struct {
+	CPUStats    cpuStats `json:"cpu_stats"`
+	PreCPUStats cpuStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+}
+
+type cpuStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  int    `json:"online_cpus"`
+}
+
+// ContainerStats samples the container's usage. The engine takes two
+// readings about a second apart for the CPU share, so this takes as long.
+func (c *Client) ContainerStats(ctx context.Context, id string) (Stats, error) {
+	var s statsJSON
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/stats", url.Values{"stream": {"false"}}, &s); err != nil {
+		return Stats{}, err
+	}
+	return s.stats(), nil
+}
+
+func (s statsJSON) stats() Stats {
+	var st Stats
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := s.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = len(s.CPUStats.CPUUsage.PercpuUsage)
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		st.CPUPercent = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+
+	// Page cache the kernel can reclaim does not count as used, as in
+	// docker stats: total_inactive_file on cgroup v1, inactive_file on v2.
+	used := s.MemoryStats.Usage
+	cache, ok := s.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = s.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < used {
+		used -= cache
+	}
+	st.MemUsage = used
+	st.MemLimit = s.MemoryStats.Limit
+	if st.MemLimit > 0 {
+		st.MemPercent = float64(used) / float64(st.MemLimit) * 100
+	}
+	return st
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// LogsOptions selects what Logs writes.
+type LogsOptions struct {
+	// Follow keeps streaming new lines until the context is cancelled or the
+	// container stops.
+	Follow bool
+	// Tail is the number of lines from the end to start with; 0 for all.
+	Tail       int
+	Timestamps bool
+}
+
+// Logs writes the container's log to stdout and stderr, split the way the
+// container wrote it. A follow ended by cancelling ctx is not an error.
+func (c *Client) Logs(ctx context.Context, id string, opts LogsOptions, stdout, stderr io.Writer) error {
+	// Without a TTY the engine multiplexes both streams into one.
+	var inspect struct {
+		Config struct {
+			Tty bool `json:"Tty"`
+		} `json:"Config"`
+	}
+	path := "/containers/" + url.PathEscape(id)
+	if err := c.getJSON(ctx, path+"/json", nil, &inspect); err != nil {
+		return err
+	}
+
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Timestamps {
+		query.Set("timestamps", "1")
+	}
+	resp, err := c.get(ctx, path+"/logs", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if inspect.Config.Tty {
+		_, err = io.Copy(stdout, resp.Body)
+	} else {
+		err = demux(resp.Body, stdout, stderr)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// demux splits a multiplexed stream. Each frame is an 8 byte header, holding
+// the stream (1 stdout, 2 stderr) and the big-endian payload size in its last
+// four bytes, followed by the payload.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package stackctl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/stackctl/internal/engine"
+)
+
+type ApplyOptions struct {
+	// Force runs the hooks and docker compose up even when nothing changed
+	// since the last apply.
+	Force bool
+	// WaitTimeout, when set, makes apply wait that long for every service to
+	// be running and healthy before it counts as a success.
+	WaitTimeout time.Duration
+	// Progress is called with the service states while waiting.
+	Progress func([]ServiceHealth)
+}
+
+// Apply renders the environment's generated files and brings its services
+// up to date.
+func Apply(ctx context.Context, cfg EnvConfig, opts ApplyOptions) error {
+	modules, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	enabled, err := LoadEnabled(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCompose(cfg, modules); err != nil {
+		return err
+	}
+	if err := syncModuleAssets(cfg); err != nil {
+		return err
+	}
+	if err := writeNginxConfs(cfg, modules); err != nil {
+		return err
+	}
+	if err := writeSystemdFiles(ctx, cfg); err != nil {
+		return err
+	}
+
+	hash, err := applyHash(cfg, modules)
+	if err != nil {
+		return err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	if !opts.Force && st.AppliedHash == hash && servicesRunning(ctx, cfg, modules) {
+		fmt.Fprintf(Out(ctx), "%s unchanged since the last apply; skipping docker compose up (use --force to run it anyway)\n", cfg.EnvName)
+		if opts.WaitTimeout > 0 {
+			if err := waitForServices(ctx, cfg, modules, opts); err != nil {
+				return err
+			}
+		}
+		if cfg.Edge {
+			if err := refreshEdgeRoutes(ctx); err != nil {
+				fmt.Fprintf(Out(ctx), "warning: %v\n", err)
+			}
+		}
+		return nil
+	}
+	if !opts.Force && st.AppliedHash == hash {
+		fmt.Fprintf(Out(ctx), "%s unchanged since the last apply, but not every service is running\n", cfg.EnvName)
+	}
+
+	if err := RunModuleHooks(ctx, cfg, HookPreApply, modules, enabled.Params); err != nil {
+		return err
+	}
+
+	if cfg.Edge {
+		if err := ensureEdgeNetwork(ctx); err != nil {
+			return err
+		}
+	}
+
+	composeArgs := ComposeBaseArgs(cfg)
+	for _, module := range modules {
+		composeArgs = append(composeArgs, "--profile", module)
+	}
+	composeArgs = append(composeArgs, "up", "-d", "--remove-orphans")
+
+	if err := RunCmdStream(ctx, "docker", composeArgs...); err != nil {
+		return rollbackAfterFailure(ctx, cfg, st, fmt.Errorf("docker compose up: %w", err))
+	}
+
+	if opts.WaitTimeout > 0 {
+		if err := waitForServices(ctx, cfg, modules, opts); err != nil {
+			return rollbackAfterFailure(ctx, cfg, st, err)
+		}
+	}
+
+	if err := RunModuleHooks(ctx, cfg, HookPostApply, modules, enabled.Params); err != nil {
+		return err
+	}
+
+	gen, err := markApplied(cfg, modules, hash)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Edge {
+		if err := refreshEdgeRoutes(ctx); err != nil {
+			fmt.Fprintf(Out(ctx), "warning: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(Out(ctx), "applied %s with modules: %s (generation %d)\n", cfg.EnvName, strings.Join(modules, ", "), gen.Number)
+	return nil
+}
+
+// servicesRunning reports whether every service docker compose up would
+// start has a running container, asking the Engine API. When the engine
+// cannot be asked the answer is no, so that apply runs up.
+func servicesRunning(ctx context.Context, cfg EnvConfig, modules []string) bool {
+	services, err := ExpectedServices(cfg, modules)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, engineTimeout)
+	defer cancel()
+	client, err := EngineClient(ctx, cfg)
+	if err != nil {
+		return false
+	}
+	containers, err := client.ListContainers(ctx, engine.ListOptions{Project: cfg.EnvName})
+	if err != nil {
+		return false
+	}
+	running := map[string]bool{}
+	for _, c := range containers {
+		if c.State == "running" {
+			running[c.Service()] = true
+		}
+	}
+	for _, svc := range services {
+		if !running[svc] {
+			return false
+		}
+	}
+	return true
+}
+
+// markApplied saves the environment as a new generation and records it as
+// the running one.
+func markApplied(cfg EnvConfig, modules []string, hash string) (Generation, error) {
+	gen, err := saveGeneration(cfg, modules, hash)
+	if err != nil {
+		return Generation{}, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return Generation{}, err
+	}
+	st.AppliedHash = hash
+	st.Generation = gen.Number
+	return gen, WriteState(cfg, st)
+}
+
+// rollbackAfterFailure puts the generated files of the last good generation
+// back after a failed apply. The inputs are left as the user changed them.
+// The apply's error is returned either way.
+func rollbackAfterFailure(ctx context.Context, cfg EnvConfig, st EnvState, applyErr error) error {
+	if st.Generation == 0 {
+		return applyErr
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(Out(ctx), "apply interrupted; generation %d is still the last good one (stackctl rollback --env %s puts it back)\n", st.Generation, cfg.EnvName)
+		return applyErr
+	}
+	fmt.Fprintf(Out(ctx), "apply failed: %v\nrolling back to generation %d\n", applyErr, st.Generation)
+	if err := rollbackTo(ctx, cfg, st.Generation, false); err != nil {
+		return fmt.Errorf("%w; rollback to generation %d also failed: %v", applyErr, st.Generation, err)
+	}
+	fmt.Fprintf(Out(ctx), "enabled.yml, apps.yml and .env were left as they are; apply again once the failure is fixed, or put them back too with stackctl rollback --env %s --to %d\n", cfg.EnvName, st.Generation)
+	return fmt.Errorf("%w (rolled back to generation %d)", applyErr, st.Generation)
+}
+
+func waitForServices(ctx context.Context, cfg EnvConfig, modules []string, opts ApplyOptions) error {
+	services, err := ExpectedServices(cfg, modules)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "waiting up to %s for %d services\n", opts.WaitTimeout, len(services))
+	states, err := WaitHealthy(ctx, cfg, modules, services, opts.WaitTimeout, opts.Progress)
+	if err != nil {
+		if len(states) > 0 {
+			fmt.Fprint(Out(ctx), HealthTable(states))
+		}
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "all %d services are up\n", len(states))
+	return nil
+}
@@ -0,0 +1,69 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyRollsBackGeneratedFiles(t *testing.T) {
+	cfg := newTestEnv(t, map[string]string{
+		".env":        "DOMAIN=dev.example.com\nADMIN_EMAIL=admin@example.com\n",
+		"enabled.yml": "version: 2\nmodules:\n  - postgres\n",
+	})
+	if err := HydrateFromDotEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(WithRunner(context.Background(), &RecordingRunner{}), cfg, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	compose := readTestFile(t, filepath.Join(cfg.EnvDir, "compose.yml"))
+	nginx, _ := filepath.Glob(filepath.Join(cfg.EnvDir, "nginx", "conf.d", "*"))
+
+	// Enable grafana and change .env, then fail the up that would start it.
+	enabled := "version: 2\nmodules:\n  - grafana\n  - postgres\n"
+	env := "DOMAIN=dev.example.com\nADMIN_EMAIL=admin@example.com\nEXTRA=1\n"
+	writeTestFile(t, filepath.Join(cfg.EnvDir, "enabled.yml"), enabled)
+	writeTestFile(t, filepath.Join(cfg.EnvDir, ".env"), env)
+	r := &RecordingRunner{Respond: func(c Cmd) (string, error) {
+		if strings.Contains(c.String(), "--profile grafana") && strings.Contains(c.String(), " up -d") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}}
+	err := Apply(WithRunner(context.Background(), r), cfg, ApplyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "rolled back to generation 1") {
+		t.Fatalf("err = %v, want a rollback to generation 1", err)
+	}
+
+	if got := readTestFile(t, filepath.Join(cfg.EnvDir, "compose.yml")); got != compose {
+		t.Errorf("compose.yml was not restored:\n%s", got)
+	}
+	if got, _ := filepath.Glob(filepath.Join(cfg.EnvDir, "nginx", "conf.d", "*")); strings.Join(got, " ") != strings.Join(nginx, " ") {
+		t.Errorf("nginx/conf.d = %v, want %v", got, nginx)
+	}
+	if got := readTestFile(t, filepath.Join(cfg.EnvDir, "enabled.yml")); got != enabled {
+		t.Errorf("enabled.yml was changed:\n%s", got)
+	}
+	if got := readTestFile(t, filepath.Join(cfg.EnvDir, ".env")); got != env {
+		t.Errorf(".env was changed:\n%s", got)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(generationsDir(cfg), replacedPrefix+"*")); len(dirs) > 0 {
+		t.Errorf("saved replaced inputs %v, which the rollback does not replace", dirs)
+	}
+
+	cmds := r.Commands()
+	last := cmds[len(cmds)-1]
+	if !strings.HasSuffix(last, "up -d --remove-orphans") || strings.Contains(last, "grafana") {
+		t.Errorf("last command = %s, want docker compose up of generation 1", last)
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Generation != 1 {
+		t.Errorf("state generation = %d, want 1", st.Generation)
+	}
+}
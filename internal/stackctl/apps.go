@@ -0,0 +1,249 @@
+package stackctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AppService is one of our own workloads declared in an environment's
+// apps.yml, next to the modules.
+type AppService struct {
+	Image string `yaml:"image"`
+	// Port is the container port the app listens on; it is only exposed on
+	// the app network.
+	Port int `yaml:"port"`
+	// Health is an HTTP path checked with wget inside the container.
+	Health string `yaml:"health"`
+	// HealthCommand replaces the wget check for images without wget.
+	HealthCommand string            `yaml:"health_command"`
+	Env           map[string]string `yaml:"env"`
+	// Volumes are host:container[:ro]. A relative host path lives under the
+	// app's data directory.
+	Volumes   []string `yaml:"volumes"`
+	Command   []string `yaml:"command"`
+	DependsOn []string `yaml:"depends_on"`
+	// Subdomain publishes the app through nginx at <subdomain>.<domain>.
+	Subdomain string `yaml:"subdomain"`
+}
+
+type AppsConfig struct {
+	Apps map[string]AppService `yaml:"apps"`
+}
+
+var (
+	appNameRegex   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	subdomainRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+)
+
+func appsPath(cfg EnvConfig) string {
+	return filepath.Join(cfg.EnvDir, "apps.yml")
+}
+
+// LoadApps reads apps.yml; a missing file means no apps.
+func LoadApps(cfg EnvConfig) (AppsConfig, error) {
+	b, err := os.ReadFile(appsPath(cfg))
+	if errors.Is(err, fs.ErrNotExist) {
+		return AppsConfig{}, nil
+	}
+	if err != nil {
+		return AppsConfig{}, err
+	}
+	var apps AppsConfig
+	if err := yaml.Unmarshal(b, &apps); err != nil {
+		return AppsConfig{}, fmt.Errorf("parse apps.yml: %w", err)
+	}
+	if err := apps.validate(); err != nil {
+		return AppsConfig{}, fmt.Errorf("apps.yml: %w", err)
+	}
+	return apps, nil
+}
+
+func (a AppsConfig) Names() []string {
+	return sortedKeys(a.Apps)
+}
+
+func (a AppsConfig) validate() error {
+	subdomains := map[string]string{}
+	for _, name := range a.Names() {
+		app := a.Apps[name]
+		if !appNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid app name %q (use lowercase letters, digits, '-' and '_')", name)
+		}
+		if app.Image == "" {
+			return fmt.Errorf("app %s: image is required", name)
+		}
+		if app.Port < 0 || app.Port > 65535 {
+			return fmt.Errorf("app %s: invalid port %d", name, app.Port)
+		}
+		if app.Health != "" && !strings.HasPrefix(app.Health, "/") {
+			return fmt.Errorf("app %s: health must be a path starting with /", name)
+		}
+		if (app.Health != "" || app.Subdomain != "") && app.Port == 0 {
+			return fmt.Errorf("app %s: health and subdomain need a port", name)
+		}
+		if app.Health != "" && app.HealthCommand != "" {
+			return fmt.Errorf("app %s: set only one of health and health_command", name)
+		}
+		for _, v := range app.Volumes {
+			if _, _, err := splitVolume(v); err != nil {
+				return fmt.Errorf("app %s: %w", name, err)
+			}
+		}
+		if app.Subdomain == "" {
+			continue
+		}
+		if !subdomainRegex.MatchString(app.Subdomain) {
+			return fmt.Errorf("app %s: invalid subdomain %q", name, app.Subdomain)
+		}
+		if other, ok := subdomains[app.Subdomain]; ok {
+			return fmt.Errorf("apps %s and %s both use subdomain %s", other, name, app.Subdomain)
+		}
+		subdomains[app.Subdomain] = name
+	}
+	return nil
+}
+
+func splitVolume(v string) (host, rest string, err error) {
+	host, rest, ok := strings.Cut(v, ":")
+	if !ok || host == "" || rest == "" {
+		return "", "", fmt.Errorf("invalid volume %q (use host:container[:ro])", v)
+	}
+	if !filepath.IsAbs(host) && strings.HasPrefix(filepath.Clean(host), "..") {
+		return "", "", fmt.Errorf("volume %q must stay inside the app data directory", v)
+	}
+	return host, rest, nil
+}
+
+// Published returns the apps with a subdomain, which get an nginx vhost.
+func (a AppsConfig) Published() []string {
+	var names []string
+	for _, name := range a.Names() {
+		if a.Apps[name].Subdomain != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// composeServices turns the apps into compose services. They have no profile
+// and so always run.
+func (a AppsConfig) composeServices(cfg EnvConfig) map[string]any {
+	services := map[string]any{}
+	for _, name := range a.Names() {
+		app := a.Apps[name]
+		svc := map[string]any{
+			"image":    app.Image,
+			"restart":  "unless-stopped",
+			"networks": []any{"app_net"},
+			"logging": map[string]any{
+				"driver":  "json-file",
+				"options": map[string]any{"max-size": "10m", "max-file": "5"},
+			},
+		}
+		if app.Port != 0 {
+			svc["expose"] = []any{fmt.Sprint(app.Port)}
+		}
+		if len(app.Env) > 0 {
+			env := map[string]any{}
+			for k, v := range app.Env {
+				env[k] = v
+			}
+			svc["environment"] = env
+		}
+		if len(app.Volumes) > 0 {
+			var volumes []any
+			for _, v := range app.Volumes {
+				host, rest, _ := splitVolume(v)
+				if !filepath.IsAbs(host) {
+					host = filepath.Join(cfg.DataRoot, cfg.EnvName, "apps", name, host)
+				}
+				volumes = append(volumes, host+":"+rest)
+			}
+			svc["volumes"] = volumes
+		}
+		if len(app.Command) > 0 {
+			command := make([]any, 0, len(app.Command))
+			for _, c := range app.Command {
+				command = append(command, c)
+			}
+			svc["command"] = command
+		}
+		if len(app.DependsOn) > 0 {
+			deps := make([]any, 0, len(app.DependsOn))
+			for _, d := range app.DependsOn {
+				deps = append(deps, d)
+			}
+			svc["depends_on"] = deps
+		}
+		test := app.HealthCommand
+		if app.Health != "" {
+			test = fmt.Sprintf("wget -q -O /dev/null http://127.0.0.1:%d%s || exit 1", app.Port, app.Health)
+		}
+		if test != "" {
+			svc["healthcheck"] = map[string]any{
+				"test":     []any{"CMD-SHELL", test},
+				"interval": "30s",
+				"timeout":  "5s",
+				"retries":  5,
+			}
+		}
+		services[name] = svc
+	}
+	return services
+}
+
+// appVhostData is what templates/apps/vhost.conf is rendered with.
+type appVhostData struct {
+	RenderData
+	Name      string
+	Subdomain string
+	Port      int
+}
+
+// renderAppVhosts renders an nginx vhost per published app as
+// apps-<name>.conf. Vhosts in conf.d of apps that are gone come back nil.
+func renderAppVhosts(cfg EnvConfig, apps AppsConfig) (map[string][]byte, error) {
+	out := map[string][]byte{}
+	published := apps.Published()
+	existing, err := filepath.Glob(filepath.Join(cfg.EnvDir, "nginx", "conf.d", "apps-*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range existing {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "apps-"), ".conf")
+		if !contains(published, name) {
+			out[filepath.Base(path)] = nil
+		}
+	}
+
+	tplPath := filepath.Join(findTemplatesDir(), "apps", "vhost.conf")
+	for _, name := range published {
+		app := apps.Apps[name]
+		data := appVhostData{RenderData: cfg.RenderData(), Name: name, Subdomain: app.Subdomain, Port: app.Port}
+		text, err := renderFile(tplPath, data)
+		if err != nil {
+			return nil, fmt.Errorf("render nginx vhost for app %s: %w", name, err)
+		}
+		out["apps-"+name+".conf"] = []byte(text)
+	}
+	return out, nil
+}
+
+func ensureAppsFile(cfg EnvConfig) error {
+	target := appsPath(cfg)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	content, err := os.ReadFile(filepath.Join(findTemplatesDir(), "apps", "apps.yml"))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(target, content, 0o640)
+}
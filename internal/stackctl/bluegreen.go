@@ -0,0 +1,190 @@
+package stackctl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+	// idleColorProfile holds the color of a blue/green service that is not
+	// active, so apply neither starts it nor removes it as an orphan.
+	idleColorProfile = "stackctl-idle"
+)
+
+// colorService returns the compose service of service's color: blue is the
+// service itself, green its <service>-green twin.
+func colorService(service, color string) string {
+	if color == colorGreen {
+		return service + "-green"
+	}
+	return service
+}
+
+func otherColor(color string) string {
+	if color == colorGreen {
+		return colorBlue
+	}
+	return colorGreen
+}
+
+// addColorServices gives every blue/green service of the enabled modules its
+// green twin and moves the inactive color to the idle profile.
+func addColorServices(root *yaml.Node, catalog Catalog, modules []string, colors map[string]string) error {
+	services := ensureMapping(root, "services")
+	for _, module := range modules {
+		for _, svc := range catalog[module].BlueGreen {
+			blue := mappingValue(services, svc)
+			if blue == nil {
+				return fmt.Errorf("module %s: blue_green service %s is not in its compose file", module, svc)
+			}
+			twin := colorService(svc, colorGreen)
+			if mappingValue(services, twin) != nil {
+				return fmt.Errorf("module %s: service %s already exists", module, twin)
+			}
+			green := copyNode(blue)
+			services.Content = append(services.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: twin}, green)
+
+			idle := green
+			if colors[svc] == colorGreen {
+				idle = blue
+			}
+			profiles := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: idleColorProfile, Style: yaml.DoubleQuotedStyle},
+			}}
+			if i := mappingIndex(idle, "profiles"); i >= 0 {
+				idle.Content[i+1] = profiles
+			} else {
+				idle.Content = append(idle.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "profiles"}, profiles)
+			}
+		}
+	}
+	return nil
+}
+
+// blueGreenServices lists the blue/green services of the enabled modules.
+func blueGreenServices(modules []string) ([]string, error) {
+	catalog, err := LoadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	for _, module := range modules {
+		services = append(services, catalog[module].BlueGreen...)
+	}
+	return services, nil
+}
+
+// withIdle returns the profiles of modules plus the idle color profile.
+func withIdle(modules []string) []string {
+	return append(append([]string(nil), modules...), idleColorProfile)
+}
+
+// colorArgs are the compose args that also see the idle colors.
+func colorArgs(cfg EnvConfig, modules []string) []string {
+	args := ComposeBaseArgs(cfg)
+	for _, profile := range withIdle(modules) {
+		args = append(args, "--profile", profile)
+	}
+	return args
+}
+
+// reloadNginx makes the environment's nginx pick up rewritten confs.
+func reloadNginx(ctx context.Context, cfg EnvConfig) error {
+	args := append(ComposeBaseArgs(cfg), "exec", "-T", "nginx", "nginx", "-s", "reload")
+	if out, err := RunCmdCapture(ctx, "docker", args...); err != nil {
+		return fmt.Errorf("reload nginx: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// switchColor records color as service's active one and points nginx at it.
+func switchColor(ctx context.Context, cfg EnvConfig, modules []string, service, color string) error {
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	if color == colorGreen {
+		if st.Colors == nil {
+			st.Colors = map[string]string{}
+		}
+		st.Colors[service] = colorGreen
+	} else {
+		delete(st.Colors, service)
+	}
+	if err := WriteState(cfg, st); err != nil {
+		return err
+	}
+	if err := writeCompose(cfg, modules); err != nil {
+		return err
+	}
+	if err := writeNginxConfs(cfg, modules); err != nil {
+		return err
+	}
+	return reloadNginx(ctx, cfg)
+}
+
+// deployBlueGreen starts the idle color of service with the current .env,
+// waits for it, switches nginx over and stops the old color. The old color
+// keeps serving until the switch, so a failure leaves it untouched.
+func deployBlueGreen(ctx context.Context, cfg EnvConfig, modules []string, service string, opts DeployOptions) error {
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	oldColor := st.Colors[service]
+	if oldColor == "" {
+		oldColor = colorBlue
+	}
+	newColor := otherColor(oldColor)
+	oldName, newName := colorService(service, oldColor), colorService(service, newColor)
+
+	if err := writeCompose(cfg, modules); err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "starting %s (%s) next to %s (%s)\n", newName, newColor, oldName, oldColor)
+	up := append(colorArgs(cfg, modules), "up", "-d", "--no-deps", "--force-recreate", newName)
+	if err := RunCmdStream(ctx, "docker", up...); err != nil {
+		return fmt.Errorf("docker compose up %s: %w", newName, err)
+	}
+	remove := func() {
+		rm := append(colorArgs(cfg, modules), "rm", "-s", "-f", newName)
+		if err := RunCmdStream(ctx, "docker", rm...); err != nil {
+			fmt.Fprintf(Out(ctx), "warning: remove %s: %v\n", newName, err)
+		}
+	}
+
+	timeout := opts.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fmt.Fprintf(Out(ctx), "waiting up to %s for %s\n", timeout, newName)
+	states, err := WaitHealthy(ctx, cfg, withIdle(modules), []string{newName}, timeout, opts.Progress)
+	if err != nil {
+		if len(states) > 0 {
+			fmt.Fprint(Out(ctx), HealthTable(states))
+		}
+		remove()
+		return err
+	}
+
+	fmt.Fprintf(Out(ctx), "switching nginx from %s to %s\n", oldName, newName)
+	if err := switchColor(ctx, cfg, modules, service, newColor); err != nil {
+		if backErr := switchColor(ctx, cfg, modules, service, oldColor); backErr != nil {
+			return fmt.Errorf("%w; switching back to %s also failed: %v", err, oldColor, backErr)
+		}
+		remove()
+		return err
+	}
+
+	stop := append(colorArgs(cfg, modules), "stop", oldName)
+	if err := RunCmdStream(ctx, "docker", stop...); err != nil {
+		fmt.Fprintf(Out(ctx), "warning: stop %s: %v\n", oldName, err)
+	}
+	fmt.Fprintf(Out(ctx), "%s now runs %s\n", service, newColor)
+	return nil
+}
@@ -0,0 +1,295 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ModuleInfo is a module manifest, loaded from <search path>/<name>/module.yml.
+type ModuleInfo struct {
+	Name        string       `yaml:"name"`
+	Description string       `yaml:"description"`
+	Category    string       `yaml:"category"`
+	Ports       []ModulePort `yaml:"ports"`
+	Depends     []string     `yaml:"depends"`
+	// Recommends are suggested alongside the module but never enabled
+	// automatically.
+	Recommends []string `yaml:"recommends"`
+	// Provides names roles such as "reverse-proxy" or "log-shipper"; two
+	// enabled modules may not provide the same role.
+	Provides []string `yaml:"provides"`
+	// Conflicts lists modules or roles that cannot be enabled together with
+	// this module.
+	Conflicts []string `yaml:"conflicts"`
+	// Params is the schema of the settings the module's templates accept.
+	Params []ModuleParam `yaml:"params"`
+	// DataDirs are the module's directories under /srv/data/<env>, removed
+	// by disable --archive/--purge. Defaults to the module name.
+	DataDirs []string `yaml:"data_dirs"`
+	// NginxUpstreams are services the shared nginx proxies to; nginx waits
+	// for them to start. Any <module>/nginx/*.conf is rendered into the
+	// environment's nginx conf.d while the module is enabled.
+	NginxUpstreams []string `yaml:"nginx_upstreams"`
+	// BlueGreen are services of the module that deploy --blue-green can roll
+	// out as a second color behind nginx.
+	BlueGreen []string `yaml:"blue_green"`
+	// Hooks maps a lifecycle phase (pre-apply, post-apply, pre-disable,
+	// post-disable) to the hooks run at that phase, in order.
+	Hooks map[string][]Hook `yaml:"hooks"`
+
+	// Dir is the module's template directory; it is not part of the manifest.
+	Dir string `yaml:"-"`
+}
+
+// ModulePort is a container port a module publishes on the loopback
+// interface. Default is the preferred host port; the allocator picks another
+// one when a different environment already holds it.
+type ModulePort struct {
+	Name      string `yaml:"name"`
+	Container int    `yaml:"container"`
+	Default   int    `yaml:"default"`
+}
+
+func (p ModulePort) String() string {
+	return fmt.Sprintf("%s:%d", LoopbackHost, p.Default)
+}
+
+// DefaultPorts lists the preferred host bindings of a module.
+func (m ModuleInfo) DefaultPorts() []string {
+	ports := make([]string, 0, len(m.Ports))
+	for _, p := range m.Ports {
+		ports = append(ports, p.String())
+	}
+	return ports
+}
+
+// Catalog maps module names to their manifests.
+type Catalog map[string]ModuleInfo
+
+// categoryOrder is the display order of the stock categories; any other
+// category is listed after them alphabetically.
+var categoryOrder = []string{"Core", "Observability", "Infrastructure", "Utilities"}
+
+// reservedEnvDirs are stackctl's own directories in an environment directory.
+// Modules sync their assets to a directory of their name there, so no module
+// may be called like one of them.
+var reservedEnvDirs = []string{"nginx", "systemd", generationsDirName, ".git"}
+
+// ModuleSearchPaths returns the directories modules are loaded from: the
+// built-in templates/modules, then every directory listed in
+// STACKCTL_MODULE_PATH, then module_paths from the host config.
+func ModuleSearchPaths() ([]string, error) {
+	paths := []string{filepath.Join(findTemplatesDir(), "modules")}
+	add := func(p string) {
+		if p != "" && !contains(paths, filepath.Clean(p)) {
+			paths = append(paths, filepath.Clean(p))
+		}
+	}
+
+	for _, p := range filepath.SplitList(os.Getenv("STACKCTL_MODULE_PATH")) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		add(abs)
+	}
+
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range conf.ModulePaths {
+		p = strings.TrimSpace(p)
+		if p != "" && !filepath.IsAbs(p) {
+			p = filepath.Join(GetStackRoot(), p)
+		}
+		add(p)
+	}
+	return paths, nil
+}
+
+// MissingModulePaths returns the module search paths besides the built-in
+// one that do not exist. LoadCatalog skips them.
+func MissingModulePaths() []string {
+	paths, err := ModuleSearchPaths()
+	if err != nil {
+		return nil
+	}
+	var missing []string
+	for _, p := range paths[1:] {
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// loadCatalog is LoadCatalog for a command, warning once per run about
+// module search paths that do not exist.
+func loadCatalog(ctx context.Context) (Catalog, error) {
+	for _, p := range MissingModulePaths() {
+		warnOnce(ctx, "module path %s does not exist; skipping it", p)
+	}
+	return LoadCatalog()
+}
+
+// LoadCatalog builds the module catalog from every module search path.
+// A module name may only be defined once across all paths. Only the built-in
+// path has to exist.
+func LoadCatalog() (Catalog, error) {
+	paths, err := ModuleSearchPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := Catalog{}
+	for i, modulesDir := range paths {
+		entries, err := os.ReadDir(modulesDir)
+		if i > 0 && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read modules: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			info, err := loadModuleManifest(filepath.Join(modulesDir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if prev, ok := catalog[info.Name]; ok {
+				return nil, fmt.Errorf("module %s is defined twice: %s and %s", info.Name, prev.Dir, info.Dir)
+			}
+			catalog[info.Name] = info
+		}
+	}
+
+	for _, info := range catalog {
+		for _, dep := range info.Depends {
+			if _, ok := catalog[dep]; !ok {
+				return nil, fmt.Errorf("module %s depends on unknown module %s", info.Name, dep)
+			}
+		}
+	}
+	return catalog, nil
+}
+
+func loadModuleManifest(dir string) (ModuleInfo, error) {
+	dirName := filepath.Base(dir)
+	info := ModuleInfo{Name: dirName, Category: "Other"}
+
+	b, err := os.ReadFile(filepath.Join(dir, "module.yml"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return ModuleInfo{}, err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(b, &info); err != nil {
+			return ModuleInfo{}, fmt.Errorf("parse module %s manifest: %w", dirName, err)
+		}
+	}
+
+	if info.Name != dirName {
+		return ModuleInfo{}, fmt.Errorf("module manifest in %s declares name %q", dir, info.Name)
+	}
+	if contains(reservedEnvDirs, info.Name) || strings.HasPrefix(info.Name, ".") {
+		return ModuleInfo{}, fmt.Errorf("module %s in %s: the name is taken by stackctl's own files in the environment directory", info.Name, dir)
+	}
+	if info.Category == "" {
+		info.Category = "Other"
+	}
+	for _, d := range info.DataDirs {
+		if !isLocalPath(d) || d != filepath.Clean(d) {
+			return ModuleInfo{}, fmt.Errorf("module %s: data_dirs entry %q must be a clean relative path inside the data directory", info.Name, d)
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range info.Ports {
+		if p.Name == "" || p.Container <= 0 {
+			return ModuleInfo{}, fmt.Errorf("module %s: every port needs a name and a container port", info.Name)
+		}
+		if seen[p.Name] {
+			return ModuleInfo{}, fmt.Errorf("module %s: duplicate port %s", info.Name, p.Name)
+		}
+		seen[p.Name] = true
+	}
+	if err := validateParamSchema(info.Name, info.Params); err != nil {
+		return ModuleInfo{}, err
+	}
+	if err := validateHooks(info); err != nil {
+		return ModuleInfo{}, err
+	}
+	info.Dir = dir
+	return info, nil
+}
+
+func (c Catalog) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c Catalog) Categories() []string {
+	var cats []string
+	for _, cat := range categoryOrder {
+		for _, info := range c {
+			if info.Category == cat {
+				cats = append(cats, cat)
+				break
+			}
+		}
+	}
+	var rest []string
+	for _, info := range c {
+		if !contains(categoryOrder, info.Category) && !contains(rest, info.Category) {
+			rest = append(rest, info.Category)
+		}
+	}
+	sort.Strings(rest)
+	return append(cats, rest...)
+}
+
+// ByCategory groups the sorted module names by category.
+func (c Catalog) ByCategory() map[string][]string {
+	grouped := map[string][]string{}
+	for _, name := range c.Names() {
+		cat := c[name].Category
+		grouped[cat] = append(grouped[cat], name)
+	}
+	return grouped
+}
+
+// Dependents lists the modules that declare name as a direct dependency.
+func (c Catalog) Dependents(name string) []string {
+	var out []string
+	for _, mod := range c.Names() {
+		if contains(c[mod].Depends, name) {
+			out = append(out, mod)
+		}
+	}
+	return out
+}
+
+func (c Catalog) portSummary(name string) string {
+	m, ok := c[name]
+	if !ok || len(m.Ports) == 0 {
+		return "-"
+	}
+	return strings.Join(m.DefaultPorts(), ",")
+}
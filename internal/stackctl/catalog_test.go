@@ -0,0 +1,143 @@
+package stackctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testCatalog has a dependency chain app -> api -> db, a cycle a -> b -> c
+// -> a and two reverse proxies.
+var testCatalog = Catalog{
+	"app":     {Name: "app", Depends: []string{"api"}, Recommends: []string{"metrics", "missing"}},
+	"api":     {Name: "api", Depends: []string{"db"}},
+	"db":      {Name: "db"},
+	"worker":  {Name: "worker", Depends: []string{"db"}},
+	"metrics": {Name: "metrics"},
+	"a":       {Name: "a", Depends: []string{"b"}},
+	"b":       {Name: "b", Depends: []string{"c"}},
+	"c":       {Name: "c", Depends: []string{"a"}},
+	"broken":  {Name: "broken", Depends: []string{"gone"}},
+	"nginx":   {Name: "nginx", Provides: []string{"reverse-proxy"}},
+	"traefik": {Name: "traefik", Provides: []string{"reverse-proxy"}},
+	"caddy":   {Name: "caddy", Conflicts: []string{"reverse-proxy"}},
+	"mysql":   {Name: "mysql", Conflicts: []string{"db"}},
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      Resolution
+		err       string
+	}{
+		{
+			name:      "transitive dependencies",
+			requested: []string{"app"},
+			want: Resolution{
+				Modules:     []string{"api", "app", "db"},
+				Order:       []string{"db", "api", "app"},
+				Added:       map[string]string{"api": "app", "db": "api"},
+				Recommended: []string{"metrics"},
+			},
+		},
+		{
+			name:      "requested dependencies are not added",
+			requested: []string{"worker", "db", "api"},
+			want: Resolution{
+				Modules: []string{"api", "db", "worker"},
+				Order:   []string{"db", "api", "worker"},
+				Added:   map[string]string{},
+			},
+		},
+		{
+			name:      "nothing requested",
+			requested: nil,
+			want:      Resolution{Modules: []string{}, Added: map[string]string{}},
+		},
+		{
+			name:      "unknown module",
+			requested: []string{"nope"},
+			err:       "unknown module: nope",
+		},
+		{
+			name:      "unknown dependency",
+			requested: []string{"broken"},
+			err:       "module broken depends on unknown module gone",
+		},
+		{
+			name:      "cycle",
+			requested: []string{"a"},
+			err:       "dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name:      "cycle entered midway",
+			requested: []string{"b"},
+			err:       "dependency cycle: b -> c -> a -> b",
+		},
+		{
+			name:      "same role provided twice",
+			requested: []string{"nginx", "traefik"},
+			err:       "modules nginx and traefik both provide reverse-proxy; enable only one",
+		},
+		{
+			name:      "conflict with a role",
+			requested: []string{"caddy", "traefik"},
+			err:       "module caddy conflicts with traefik (provides reverse-proxy)",
+		},
+		{
+			name:      "conflict with an implied dependency",
+			requested: []string{"mysql", "worker"},
+			err:       "module mysql conflicts with db",
+		},
+		{
+			name:      "conflicting role not enabled",
+			requested: []string{"caddy", "db"},
+			want: Resolution{
+				Modules: []string{"caddy", "db"},
+				Order:   []string{"caddy", "db"},
+				Added:   map[string]string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testCatalog.Resolve(tt.requested)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve(%v) = %+v, want %+v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnabledDependents(t *testing.T) {
+	tests := []struct {
+		name    string
+		module  string
+		enabled []string
+		want    []string
+	}{
+		{"direct and transitive", "db", []string{"api", "app", "db", "worker"}, []string{"api", "app", "worker"}},
+		{"only enabled modules", "db", []string{"db", "worker"}, []string{"worker"}},
+		{"leaf", "app", []string{"api", "app", "db"}, nil},
+		{"not itself in a cycle", "a", []string{"a", "b", "c"}, []string{"b", "c"}},
+		{"unknown enabled module", "db", []string{"db", "gone"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testCatalog.EnabledDependents(tt.module, tt.enabled)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnabledDependents(%s, %s) = %v, want %v", tt.module, strings.Join(tt.enabled, " "), got, tt.want)
+			}
+		})
+	}
+}
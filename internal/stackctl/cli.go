@@ -1,76 +1,133 @@
 package stackctl
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/example/stackctl/internal/engine"
 )
 
-func Run(args []string) error {
+func Run(ctx context.Context, args []string) error {
 	if len(args) < 1 {
-		usage()
+		usage(ctx)
 		os.Exit(1)
 	}
 
 	cmd := args[0]
 	cmdArgs := args[1:]
 
+	ctx = withWarnings(ctx)
+
 	switch cmd {
 	case "init":
-		return cmdInit(cmdArgs)
+		return cmdInit(ctx, cmdArgs)
 	case "enable":
-		return cmdEnableDisable(cmdArgs, true)
+		return cmdEnableDisable(ctx, cmdArgs, true)
 	case "disable":
-		return cmdEnableDisable(cmdArgs, false)
+		return cmdEnableDisable(ctx, cmdArgs, false)
 	case "status":
-		return cmdStatus(cmdArgs)
+		return cmdStatus(ctx, cmdArgs)
+	case "plan":
+		return cmdPlan(ctx, cmdArgs)
 	case "apply":
-		return cmdApply(cmdArgs)
+		return cmdApply(ctx, cmdArgs)
+	case "deploy":
+		return cmdDeploy(ctx, cmdArgs)
+	case "promote":
+		return cmdPromote(ctx, cmdArgs)
+	case "lock":
+		return cmdLock(ctx, cmdArgs)
+	case "rollback":
+		return cmdRollback(ctx, cmdArgs)
+	case "history":
+		return cmdHistory(ctx, cmdArgs)
+	case "config":
+		return cmdConfig(ctx, cmdArgs)
+	case "logs":
+		return cmdLogs(ctx, cmdArgs)
 	case "backup":
-		return cmdBackup(cmdArgs)
+		return cmdBackup(ctx, cmdArgs)
+	case "edge":
+		return cmdEdge(ctx, cmdArgs)
 	case "doctor":
-		return RunDoctor()
+		return RunDoctor(ctx)
 	case "help", "--help", "-h":
-		usage()
+		usage(ctx)
 		return nil
 	default:
 		return fmt.Errorf("unknown command: %s", cmd)
 	}
 }
 
-func usage() {
-	fmt.Println(`stackctl - new VM to production-ready Docker Compose stack
+func usage(ctx context.Context) {
+	fmt.Fprintln(Out(ctx), `stackctl - new VM to production-ready Docker Compose stack
 
 Usage:
-  stackctl init --env dev|qa|prod [--domain example.com] [--email admin@example.com]
-  stackctl enable <module> --env dev|qa|prod
-  stackctl disable <module> --env dev|qa|prod
-  stackctl status --env dev|qa|prod
-  stackctl apply --env dev|qa|prod
-  stackctl backup --env dev|qa|prod
+  stackctl init --env <env> [--domain example.com] [--email admin@example.com] [--preset full|web|api|static|minimal]
+  stackctl enable <module> --env <env> [--set key=value ...]
+  stackctl disable <module> --env <env> [--cascade] [--archive | --purge [--yes]]
+  stackctl status --env <env>
+  stackctl plan --env <env>         # show what apply would change
+  stackctl apply --env <env> [--force] [--wait [--timeout 5m]]
+  stackctl deploy <service> --env <env> --tag <tag> [--timeout 5m] [--blue-green]
+  stackctl promote --from <env> --to <env> [--only images,modules,params] [--yes]
+  stackctl rollback --env <env> [--to N | --list]
+  stackctl lock --env <env> [--check]  # pin images to digests in images.lock
+  stackctl history --env <env> [--user u] [--command apply] [--since 24h] [--failed] [--limit N] [--json]
+  stackctl logs <service> --env <env> [-f] [--tail N]
+  stackctl backup --env <env>
+  stackctl edge init|apply|status|disable  # shared 80/443 proxy for all environments
   stackctl doctor
   stackctl setup                    # interactive setup wizard
-  stackctl modules [--env dev|qa|prod]  # module manager
-  stackctl dash [--env dev|qa|prod]     # status dashboard
-  stackctl config [--env dev|qa|prod]   # configuration editor
+  stackctl modules [--env <env>]    # module manager
+  stackctl dash [--env <env>]       # status dashboard
+  stackctl config [--env <env>]     # configuration editor
+  stackctl config track --env <env> # keep the env dir in git, committed after every change
+  stackctl config log --env <env> [--limit N] [--patch]
+  stackctl config revert <rev> --env <env>
+
+Environments:
+  <env> is any Compose project name (lowercase letters, digits, '-' and '_'),
+  e.g. dev, qa, prod, staging or demo-acme.
+  Commands that change an environment lock it; pass --wait-lock 5m to wait
+  for another run instead of failing.
 
 Available modules:`)
 
-	names := SortedModuleNames()
-	for _, name := range names {
-		m := ModuleCatalog[name]
-		fmt.Printf("  - %-14s %-45s ports: %s\n", m.Name, m.Description, sortedModulePorts(name))
+	catalog, err := LoadCatalog()
+	if err != nil {
+		fmt.Fprintf(Out(ctx), "  (unavailable: %v)\n", err)
+		return
+	}
+	for _, name := range catalog.Names() {
+		m := catalog[name]
+		fmt.Fprintf(Out(ctx), "  - %-14s %-45s ports: %s\n", m.Name, m.Description, catalog.portSummary(name))
+	}
+
+	// Only worth listing once module packs are configured.
+	if paths, err := ModuleSearchPaths(); err == nil && len(paths) > 1 {
+		fmt.Fprintln(Out(ctx), "\nModule search path (STACKCTL_MODULE_PATH, module_paths in stackctl.yml):")
+		for _, p := range paths {
+			fmt.Fprintf(Out(ctx), "  - %s\n", p)
+		}
 	}
 }
 
-func cmdInit(args []string) error {
+func cmdInit(ctx context.Context, args []string) (err error) {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
-	env := fs.String("env", "", "environment name: dev, qa, or prod")
+	env := fs.String("env", "", "environment name, e.g. dev, qa, prod or staging")
 	domain := fs.String("domain", "example.com", "base domain")
 	email := fs.String("email", "admin@example.com", "ops email")
+	preset := fs.String("preset", "", "starting modules for a new environment (see templates/presets.yml)")
+	waitLock := waitLockFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -82,20 +139,43 @@ func cmdInit(args []string) error {
 	cfg.Domain = *domain
 	cfg.Email = *email
 
-	return RunInit(cfg)
+	run, err := startCommand(ctx, cfg, "init", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+
+	return RunInit(ctx, cfg, *preset)
 }
 
-func cmdEnableDisable(args []string, enable bool) error {
+func cmdEnableDisable(ctx context.Context, args []string, enable bool) (err error) {
 	if len(args) == 0 {
 		return errors.New("module is required")
 	}
 	module := args[0]
-	if _, ok := ModuleCatalog[module]; !ok {
+	catalog, err := loadCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	// A module whose pack was removed from the search path can still be
+	// disabled; it is only taken out of enabled.yml.
+	if _, ok := catalog[module]; !ok && enable {
 		return fmt.Errorf("unknown module: %s", module)
 	}
 
 	fs := flag.NewFlagSet("toggle", flag.ContinueOnError)
 	env := fs.String("env", "", "environment name")
+	var sets stringList
+	var cascade, archive, purge, yes *bool
+	if enable {
+		fs.Var(&sets, "set", "module param as key=value (repeatable, empty value resets to default)")
+	} else {
+		cascade = fs.Bool("cascade", false, "also disable enabled modules that depend on this one")
+		archive = fs.Bool("archive", false, "archive the module's data into the backup root, then remove it")
+		purge = fs.Bool("purge", false, "remove the module's data and containers after confirmation")
+		yes = fs.Bool("yes", false, "do not ask for confirmation with --purge")
+	}
+	waitLock := waitLockFlag(fs)
 	if err := fs.Parse(args[1:]); err != nil {
 		return err
 	}
@@ -105,27 +185,86 @@ func cmdEnableDisable(args []string, enable bool) error {
 		return err
 	}
 
+	command := "enable"
+	if !enable {
+		command = "disable"
+	}
+	run, err := startCommand(ctx, cfg, command, args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+
+	if !enable {
+		// The disable hooks render {{.Domain}} and get STACKCTL_DOMAIN.
+		if err := HydrateFromDotEnv(&cfg); err != nil {
+			return err
+		}
+		opts := DisableOptions{Cascade: *cascade, Mode: DisableKeepData}
+		switch {
+		case *archive && *purge:
+			return errors.New("use either --archive or --purge, not both")
+		case *archive:
+			opts.Mode = DisableArchive
+		case *purge:
+			opts.Mode = DisablePurge
+			opts.Confirm = func(modules, paths []string) bool {
+				return *yes || confirmPurge(ctx, cfg, modules, paths)
+			}
+		}
+		disabled, err := DisableModule(ctx, cfg, catalog, module, opts)
+		if err != nil {
+			return err
+		}
+		verb := "already disabled"
+		if len(disabled) > 0 {
+			verb = "disabled"
+		}
+		fmt.Fprintf(Out(ctx), "%s %s for %s\n", module, verb, cfg.EnvName)
+		fmt.Fprintf(Out(ctx), "run: stackctl apply --env %s\n", cfg.EnvName)
+		return nil
+	}
+
+	params, err := ParseSetFlags(sets)
+	if err != nil {
+		return err
+	}
 	current, err := LoadEnabled(cfg)
 	if err != nil {
 		return err
 	}
 
-	changed := false
-	if enable {
-		if !contains(current.Modules, module) {
-			current.Modules = append(current.Modules, module)
-			changed = true
+	verb := "already enabled"
+	if !contains(current.Modules, module) {
+		res, err := catalog.Resolve(append(append([]string{}, current.Modules...), module))
+		if err != nil {
+			return fmt.Errorf("cannot enable %s: %w", module, err)
 		}
-	} else {
-		filtered := make([]string, 0, len(current.Modules))
-		for _, item := range current.Modules {
-			if item != module {
-				filtered = append(filtered, item)
+		prev, _ := catalog.Resolve(current.Modules)
+		for _, dep := range res.Order {
+			if by, ok := res.Added[dep]; ok && !contains(prev.Modules, dep) {
+				fmt.Fprintf(Out(ctx), "also enabling %s (required by %s)\n", dep, by)
 			}
 		}
-		if len(filtered) != len(current.Modules) {
-			current.Modules = filtered
-			changed = true
+		for _, rec := range res.Recommended {
+			if !contains(catalog[module].Recommends, rec) {
+				continue
+			}
+			fmt.Fprintf(Out(ctx), "recommended: %s (stackctl enable %s --env %s)\n", rec, rec, cfg.EnvName)
+		}
+		current.Modules = append(current.Modules, module)
+		verb = "enabled"
+	}
+	if len(params) > 0 {
+		current.SetParams(module, params)
+		if _, err := catalog[module].ResolveParams(current.Params[module]); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(params) {
+			fmt.Fprintf(Out(ctx), "set %s %s=%s\n", module, key, params[key])
+		}
+		if verb != "enabled" {
+			verb = "updated"
 		}
 	}
 
@@ -134,24 +273,47 @@ func cmdEnableDisable(args []string, enable bool) error {
 		return err
 	}
 
-	verb := "already disabled"
-	if enable {
-		verb = "already enabled"
+	fmt.Fprintf(Out(ctx), "%s %s for %s\n", module, verb, cfg.EnvName)
+	fmt.Fprintf(Out(ctx), "run: stackctl apply --env %s\n", cfg.EnvName)
+	return nil
+}
+
+// confirmPurge asks on stdin before module data is deleted.
+func confirmPurge(ctx context.Context, cfg EnvConfig, modules, paths []string) bool {
+	fmt.Fprintf(Out(ctx), "--purge permanently deletes the data of %s in %s:\n", strings.Join(modules, ", "), cfg.EnvName)
+	for _, p := range paths {
+		fmt.Fprintf(Out(ctx), "  %s\n", p)
 	}
-	if changed {
-		if enable {
-			verb = "enabled"
-		} else {
-			verb = "disabled"
-		}
+	return confirm(ctx, "continue?")
+}
+
+// waitLockFlag adds --wait-lock to a command that takes the environment's
+// lock.
+func waitLockFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("wait-lock", 0, "wait this long for another stackctl run on the environment to finish")
+}
+
+// startCommand takes the environment's lock for a stackctl command that
+// changes it; finishing the run records the command in the history. Without
+// --wait-lock it fails right away when another run holds the lock.
+func startCommand(ctx context.Context, cfg EnvConfig, cmd string, args []string, wait time.Duration) (*EnvRun, error) {
+	command := strings.TrimSpace("stackctl " + cmd + " " + strings.Join(args, " "))
+	run, err := StartEnvRun(ctx, cfg, command, wait)
+	if errors.Is(err, ErrEnvLocked) && wait == 0 {
+		return nil, fmt.Errorf("%w; try again when it is done or pass --wait-lock 5m", err)
 	}
+	return run, err
+}
 
-	fmt.Printf("%s %s for %s\n", module, verb, cfg.EnvName)
-	fmt.Printf("run: stackctl apply --env %s\n", cfg.EnvName)
-	return nil
+// confirm asks a yes/no question on stdin; anything but yes is no.
+func confirm(ctx context.Context, question string) bool {
+	fmt.Fprintf(Out(ctx), "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
-func cmdStatus(args []string) error {
+func cmdStatus(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("status", flag.ContinueOnError)
 	env := fs.String("env", "", "environment name")
 	if err := fs.Parse(args); err != nil {
@@ -167,30 +329,79 @@ func cmdStatus(args []string) error {
 		return err
 	}
 
-	modules, err := LoadEnabledModules(cfg)
+	modules, err := LoadEnabledModules(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("environment: %s\n", cfg.EnvName)
-	fmt.Printf("path: %s\n", cfg.EnvDir)
-	fmt.Printf("enabled modules: %s\n", strings.Join(modules, ", "))
+	fmt.Fprintf(Out(ctx), "environment: %s\n", cfg.EnvName)
+	fmt.Fprintf(Out(ctx), "path: %s\n", cfg.EnvDir)
+	fmt.Fprintf(Out(ctx), "enabled modules: %s\n", strings.Join(modules, ", "))
+	if enabled, err := LoadEnabled(cfg); err == nil {
+		if lines := ParamLines(enabled); len(lines) > 0 {
+			fmt.Fprintln(Out(ctx), "module params:")
+			for _, line := range lines {
+				fmt.Fprintf(Out(ctx), "  %s\n", line)
+			}
+		}
+	}
+	if apps, err := LoadApps(cfg); err != nil {
+		fmt.Fprintf(Out(ctx), "apps: %v\n", err)
+	} else if len(apps.Apps) > 0 {
+		fmt.Fprintf(Out(ctx), "apps: %s\n", strings.Join(apps.Names(), ", "))
+	}
+	if st, err := LoadState(cfg); err == nil && len(st.Colors) > 0 {
+		var colors []string
+		for _, svc := range sortedKeys(st.Colors) {
+			colors = append(colors, svc+"="+st.Colors[svc])
+		}
+		fmt.Fprintf(Out(ctx), "blue/green: %s (others blue)\n", strings.Join(colors, ", "))
+	}
+	if lines := PortLines(cfg); len(lines) > 0 {
+		fmt.Fprintln(Out(ctx), "allocated ports:")
+		for _, line := range lines {
+			fmt.Fprintf(Out(ctx), "  %s\n", line)
+		}
+	}
 
 	composeArgs := ComposeBaseArgs(cfg)
 	composeArgs = append(composeArgs, "ps")
-	output, cmdErr := RunCmdCapture("docker", composeArgs...)
+	output, cmdErr := RunCmdCapture(ctx, "docker", composeArgs...)
 	if cmdErr != nil {
-		fmt.Println("docker compose status unavailable:")
-		fmt.Println(strings.TrimSpace(output))
+		fmt.Fprintln(Out(ctx), "docker compose status unavailable:")
+		fmt.Fprintln(Out(ctx), strings.TrimSpace(output))
 		return nil
 	}
-	fmt.Println(output)
+	fmt.Fprintln(Out(ctx), output)
 	return nil
 }
 
-func cmdApply(args []string) error {
+func cmdPlan(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
+	}
+
+	if err := HydrateFromDotEnv(&cfg); err != nil {
+		return err
+	}
+
+	return RunPlan(ctx, cfg)
+}
+
+func cmdApply(ctx context.Context, args []string) (err error) {
 	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
 	env := fs.String("env", "", "environment name")
+	force := fs.Bool("force", false, "run docker compose up even if nothing changed")
+	wait := fs.Bool("wait", false, "wait for every service to be running and healthy")
+	timeout := fs.Duration("timeout", DefaultWaitTimeout, "how long --wait waits")
+	waitLock := waitLockFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -200,45 +411,316 @@ func cmdApply(args []string) error {
 		return err
 	}
 
+	run, err := startCommand(ctx, cfg, "apply", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+
 	if err := HydrateFromDotEnv(&cfg); err != nil {
 		return err
 	}
 
-	modules, err := LoadEnabledModules(cfg)
+	opts := ApplyOptions{Force: *force}
+	if *wait {
+		opts.WaitTimeout = *timeout
+		opts.Progress = printHealthChanges(ctx)
+	}
+	return Apply(ctx, cfg, opts)
+}
+
+func cmdDeploy(ctx context.Context, args []string) (err error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("service is required")
+	}
+	service := args[0]
+
+	fs := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	tag := fs.String("tag", "", "image tag to roll out")
+	timeout := fs.Duration("timeout", DefaultWaitTimeout, "how long the service gets to become healthy")
+	blueGreen := fs.Bool("blue-green", false, "start the new version next to the old one and switch nginx over")
+	waitLock := waitLockFlag(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
 	if err != nil {
 		return err
 	}
 
-	if err := writeCompose(cfg, modules); err != nil {
+	run, err := startCommand(ctx, cfg, "deploy", args, *waitLock)
+	if err != nil {
 		return err
 	}
-	if err := syncModuleAssets(cfg); err != nil {
+	defer func() { run.Finish(ctx, err) }()
+
+	if err := HydrateFromDotEnv(&cfg); err != nil {
 		return err
 	}
-	if err := writeNginxConfs(cfg, modules); err != nil {
+
+	return Deploy(ctx, cfg, service, DeployOptions{
+		Tag:         *tag,
+		WaitTimeout: *timeout,
+		Progress:    printHealthChanges(ctx),
+		BlueGreen:   *blueGreen,
+	})
+}
+
+func cmdPromote(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("promote", flag.ContinueOnError)
+	fromEnv := fs.String("from", "", "environment to copy from")
+	toEnv := fs.String("to", "", "environment to copy to")
+	only := fs.String("only", strings.Join(PromoteParts, ","), "parts to promote: images, modules, params")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	waitLock := waitLockFlag(fs)
+	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	if err := writeSystemdFiles(cfg); err != nil {
+	if *fromEnv == "" || *toEnv == "" {
+		return errors.New("--from and --to are required")
+	}
+	if *fromEnv == *toEnv {
+		return errors.New("--from and --to must differ")
+	}
+	var parts []string
+	for _, part := range strings.Split(*only, ",") {
+		part = strings.TrimSpace(part)
+		if !contains(PromoteParts, part) {
+			return fmt.Errorf("unknown part %q for --only (known: %s)", part, strings.Join(PromoteParts, ", "))
+		}
+		parts = append(parts, part)
+	}
+
+	from, err := LoadEnvConfig(*fromEnv)
+	if err != nil {
+		return err
+	}
+	to, err := LoadEnvConfig(*toEnv)
+	if err != nil {
 		return err
 	}
 
-	composeArgs := ComposeBaseArgs(cfg)
-	for _, module := range modules {
-		composeArgs = append(composeArgs, "--profile", module)
+	run, err := startCommand(ctx, to, "promote", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+
+	changes, err := PlanPromotion(from, to)
+	if err != nil {
+		return err
+	}
+	selected, err := SelectPromotion(from, to, changes, parts)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		fmt.Fprintf(Out(ctx), "%s already matches %s in %s\n", to.EnvName, from.EnvName, strings.Join(parts, ", "))
+		return nil
+	}
+	fmt.Fprintf(Out(ctx), "promote %s -> %s:\n", from.EnvName, to.EnvName)
+	for _, c := range selected {
+		fmt.Fprintf(Out(ctx), "  %s\n", c)
+	}
+	if !*yes && !confirm(ctx, fmt.Sprintf("write these to %s?", to.EnvName)) {
+		return errors.New("aborted")
+	}
+	return ApplyPromotion(ctx, from, to, selected, parts)
+}
+
+func cmdLock(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	check := fs.Bool("check", false, "compare running containers with images.lock")
+	waitLock := waitLockFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
 	}
-	composeArgs = append(composeArgs, "up", "-d", "--remove-orphans")
 
-	if err := RunCmdStream("docker", composeArgs...); err != nil {
+	if err := HydrateFromDotEnv(&cfg); err != nil {
 		return err
 	}
 
-	fmt.Printf("applied %s with modules: %s\n", cfg.EnvName, strings.Join(modules, ", "))
+	if *check {
+		return CheckImageLock(ctx, cfg)
+	}
+	run, err := startCommand(ctx, cfg, "lock", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+	return LockImages(ctx, cfg)
+}
+
+func cmdRollback(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	to := fs.Int("to", 0, "generation to restore (default: the one before the current)")
+	list := fs.Bool("list", false, "list the saved generations")
+	waitLock := waitLockFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		lines, err := GenerationLines(cfg)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			fmt.Fprintf(Out(ctx), "no generations for %s yet; every successful apply saves one\n", cfg.EnvName)
+		}
+		for _, line := range lines {
+			fmt.Fprintln(Out(ctx), line)
+		}
+		return nil
+	}
+
+	run, err := startCommand(ctx, cfg, "rollback", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+	return Rollback(ctx, cfg, *to)
+}
+
+func cmdHistory(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	userName := fs.String("user", "", "only records of this user")
+	command := fs.String("command", "", "only records whose command contains this text, e.g. apply")
+	since := fs.Duration("since", 0, "only records of this last period, e.g. 24h")
+	failed := fs.Bool("failed", false, "only failed commands")
+	limit := fs.Int("limit", 50, "show at most this many of the newest records (0 for all)")
+	asJSON := fs.Bool("json", false, "print the records as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
+	}
+
+	filter := HistoryFilter{User: *userName, Command: *command, Failed: *failed, Limit: *limit}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+	entries, err := ReadHistory(cfg, filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(Out(ctx))
+		enc.SetEscapeHTML(false)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(Out(ctx), "no matching history for %s\n", cfg.EnvName)
+		return nil
+	}
+	for _, line := range HistoryLines(entries) {
+		fmt.Fprintln(Out(ctx), line)
+	}
 	return nil
 }
 
-func cmdBackup(args []string) error {
+func cmdLogs(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("service is required")
+	}
+	service := args[0]
+
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	follow := fs.Bool("f", false, "keep printing new lines")
+	tail := fs.Int("tail", 0, "start with this many lines from the end (0 for all)")
+	timestamps := fs.Bool("timestamps", false, "show timestamps")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
+	}
+	return ServiceLogs(ctx, cfg, service, engine.LogsOptions{Follow: *follow, Tail: *tail, Timestamps: *timestamps})
+}
+
+func cmdConfig(ctx context.Context, args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("config needs a subcommand: track, log or revert")
+	}
+	sub := args[0]
+	var rev string
+	rest := args[1:]
+	if sub == "revert" {
+		if len(rest) == 0 || strings.HasPrefix(rest[0], "-") {
+			return errors.New("revert needs a revision (see stackctl config log)")
+		}
+		rev, rest = rest[0], rest[1:]
+	}
+
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	env := fs.String("env", "", "environment name")
+	limit := fs.Int("limit", 20, "show at most this many commits (0 for all)")
+	patch := fs.Bool("patch", false, "show what each commit changed")
+	waitLock := waitLockFlag(fs)
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+
+	cfg, err := LoadEnvConfig(*env)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "log":
+		return ConfigLog(ctx, cfg, *limit, *patch)
+	case "track":
+		unlock, err := LockEnv(ctx, cfg, "stackctl config track", *waitLock)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := TrackEnvConfig(ctx, cfg); err != nil {
+			return err
+		}
+		fmt.Fprintf(Out(ctx), "%s is now tracked in git; .env is left out\n", cfg.EnvDir)
+		return nil
+	case "revert":
+		var run *EnvRun
+		if run, err = startCommand(ctx, cfg, "config", args, *waitLock); err != nil {
+			return err
+		}
+		defer func() { run.Finish(ctx, err) }()
+		return RevertEnvConfig(ctx, cfg, rev)
+	}
+	return fmt.Errorf("unknown config subcommand: %s", sub)
+}
+
+func cmdBackup(ctx context.Context, args []string) (err error) {
 	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
 	env := fs.String("env", "", "environment name")
+	waitLock := waitLockFlag(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -248,9 +730,15 @@ func cmdBackup(args []string) error {
 		return err
 	}
 
+	run, err := startCommand(ctx, cfg, "backup", args, *waitLock)
+	if err != nil {
+		return err
+	}
+	defer func() { run.Finish(ctx, err) }()
+
 	if err := HydrateFromDotEnv(&cfg); err != nil {
 		return err
 	}
 
-	return runBackup(cfg)
+	return runBackup(ctx, cfg)
 }
@@ -0,0 +1,59 @@
+package stackctl
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisableCommandModuleNotInCatalog(t *testing.T) {
+	cfg := newTestEnv(t, map[string]string{
+		".env":        "DOMAIN=dev.example.com\n",
+		"enabled.yml": "version: 2\nmodules:\n  - postgres\n  - gone\n",
+	})
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("STACKCTL_MODULE_PATH", missing)
+
+	r := &RecordingRunner{}
+	if err := Run(WithRunner(context.Background(), r), []string{"disable", "gone", "--env", "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadEnabled(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf.Modules, []string{"postgres"}) {
+		t.Errorf("enabled modules = %v, want [postgres]", conf.Modules)
+	}
+	printed := r.Printed()
+	for _, line := range []string{"gone disabled for dev", "run: stackctl apply --env dev"} {
+		if !strings.Contains(printed, line) {
+			t.Errorf("printed %q, want a line with %q", printed, line)
+		}
+	}
+	// The catalog is loaded by the command and again for the enabled
+	// modules; each warning is printed once per run.
+	for _, warning := range []string{
+		"warning: module path " + missing + " does not exist",
+		"warning: enabled module gone of dev not found",
+	} {
+		if n := strings.Count(printed, warning); n != 1 {
+			t.Errorf("printed %q %d times, want once:\n%s", warning, n, printed)
+		}
+	}
+}
+
+func TestHelpDoesNotWarn(t *testing.T) {
+	newTestEnv(t, nil)
+	t.Setenv("STACKCTL_MODULE_PATH", filepath.Join(t.TempDir(), "missing"))
+
+	r := &RecordingRunner{}
+	if err := Run(WithRunner(context.Background(), r), []string{"help"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(r.Printed(), "warning:") {
+		t.Errorf("help printed a warning:\n%s", r.Printed())
+	}
+}
@@ -1,105 +1,240 @@
 package stackctl
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
-	"time"
+	"sort"
 
 	"gopkg.in/yaml.v3"
 )
 
 func writeCompose(cfg EnvConfig, enabledModules []string) error {
+	ports, err := AllocatePorts(cfg, enabledModules)
+	if err != nil {
+		return err
+	}
+	out, err := renderCompose(cfg, enabledModules, ports)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(cfg.EnvDir, "compose.yml")
+	return os.WriteFile(target, out, 0o640)
+}
+
+// renderCompose renders the environment's compose.yml: the merged templates
+// and apps with locked images pinned and the x-stackctl bookkeeping.
+func renderCompose(cfg EnvConfig, enabledModules []string, ports map[string]map[string]int) ([]byte, error) {
+	merged, err := buildCompose(cfg, enabledModules, ports)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := LoadImageLock(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := pinImages(cfg, merged, lock)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := LoadApps(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	x := ensureMapping(merged, "x-stackctl")
+	if err := setMappingValue(x, "enabled_modules", enabledModules); err != nil {
+		return nil, err
+	}
+	if len(apps.Apps) > 0 {
+		if err := setMappingValue(x, "apps", apps.Names()); err != nil {
+			return nil, err
+		}
+	}
+	if pinned > 0 {
+		if err := setMappingValue(x, "pinned_images", pinned); err != nil {
+			return nil, err
+		}
+	}
+	hoistExtensions(merged)
+
+	// Templates and modules merge in a fixed order, so the same inputs
+	// always produce the same bytes. The hash covers the document without
+	// itself.
+	out, err := encodeYAML(merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMappingValue(x, "content_hash", hashBytes(out)); err != nil {
+		return nil, err
+	}
+	return encodeYAML(merged)
+}
+
+// buildCompose merges the base compose file, the compose files of the
+// enabled modules and the apps.
+func buildCompose(cfg EnvConfig, enabledModules []string, ports map[string]map[string]int) (*yaml.Node, error) {
 	templates := findTemplatesDir()
+	catalog, err := LoadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil, err
+	}
 	data := cfg.RenderData()
+	data.Ports = ports
+	data.Colors = st.Colors
+	for _, module := range enabledModules {
+		for _, svc := range catalog[module].NginxUpstreams {
+			svc = data.Upstream(svc)
+			if !contains(data.NginxDepends, svc) {
+				data.NginxDepends = append(data.NginxDepends, svc)
+			}
+		}
+	}
+	apps, err := LoadApps(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range apps.Published() {
+		if !contains(data.NginxDepends, name) {
+			data.NginxDepends = append(data.NginxDepends, name)
+		}
+	}
+	sort.Strings(data.NginxDepends)
+	params, err := ModuleParams(cfg, catalog, enabledModules)
+	if err != nil {
+		return nil, err
+	}
 
 	basePath := filepath.Join(templates, "base", "compose.base.yml")
 	rendered, err := renderFile(basePath, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	merged := map[string]any{}
-	if err := yaml.Unmarshal([]byte(rendered), &merged); err != nil {
-		return err
+	merged, err := parseYAMLMapping(rendered, "compose.base.yml")
+	if err != nil {
+		return nil, err
+	}
+	if merged == nil {
+		merged = &yaml.Node{Kind: yaml.MappingNode}
 	}
 
 	// Only merge enabled modules, not all modules in the catalog.
 	for _, module := range enabledModules {
-		modPath := filepath.Join(templates, "modules", module, "compose.yml")
+		info, ok := catalog[module]
+		if !ok {
+			return nil, fmt.Errorf("unknown module: %s", module)
+		}
+		modPath := filepath.Join(info.Dir, "compose.yml")
 		if _, err := os.Stat(modPath); errors.Is(err, fs.ErrNotExist) {
 			continue
 		}
+		data.Module = ModuleData{Name: module, Params: params[module]}
 		modRendered, err := renderFile(modPath, data)
 		if err != nil {
-			return fmt.Errorf("render module %s compose: %w", module, err)
+			return nil, fmt.Errorf("render module %s compose: %w", module, err)
 		}
-		var overlay map[string]any
-		if err := yaml.Unmarshal([]byte(modRendered), &overlay); err != nil {
-			return fmt.Errorf("parse module %s compose: %w", module, err)
+		overlay, err := parseYAMLMapping(modRendered, "module "+module+" compose")
+		if err != nil {
+			return nil, err
+		}
+		if overlay != nil {
+			mergeMapping(merged, overlay)
 		}
-		deepMerge(merged, overlay)
 	}
 
-	if _, ok := merged["x-stackctl"]; !ok {
-		merged["x-stackctl"] = map[string]any{}
+	if err := addColorServices(merged, catalog, enabledModules, st.Colors); err != nil {
+		return nil, err
 	}
-	x := merged["x-stackctl"].(map[string]any)
-	x["enabled_modules"] = enabledModules
-	x["generated_at"] = time.Now().UTC().Format(time.RFC3339)
 
-	out, err := yaml.Marshal(merged)
-	if err != nil {
-		return err
+	if len(apps.Apps) > 0 {
+		services := ensureMapping(merged, "services")
+		appServices := apps.composeServices(cfg)
+		for _, name := range apps.Names() {
+			if mappingValue(services, name) != nil {
+				return nil, fmt.Errorf("app %s: a module already defines service %s", name, name)
+			}
+			if err := setMappingValue(services, name, appServices[name]); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range apps.Names() {
+			for _, dep := range apps.Apps[name].DependsOn {
+				if mappingValue(services, dep) == nil {
+					return nil, fmt.Errorf("app %s depends on %s, which is not an enabled service", name, dep)
+				}
+			}
+		}
 	}
 
-	target := filepath.Join(cfg.EnvDir, "compose.yml")
-	return os.WriteFile(target, out, 0o640)
+	return merged, nil
 }
 
-func deepMerge(dst, src map[string]any) {
-	for k, v := range src {
-		existing, exists := dst[k]
-		if !exists {
-			dst[k] = v
-			continue
-		}
+func hashBytes(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-		dstMap, dstMapOK := existing.(map[string]any)
-		srcMap, srcMapOK := v.(map[string]any)
-		if dstMapOK && srcMapOK {
-			deepMerge(dstMap, srcMap)
-			continue
+// applyHash fingerprints what docker compose up and the containers consume:
+// compose.yml, the hand-edited compose.override.yml and .env, the rendered
+// nginx confs and the asset files of modules, which are edited in place.
+func applyHash(cfg EnvConfig, modules []string) (string, error) {
+	var parts [][]byte
+	for _, name := range []string{"compose.yml", "compose.override.yml", ".env"} {
+		b, err := os.ReadFile(filepath.Join(cfg.EnvDir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
 		}
-
-		dstSlice, dstSliceOK := existing.([]any)
-		srcSlice, srcSliceOK := v.([]any)
-		if dstSliceOK && srcSliceOK {
-			dst[k] = append(dstSlice, srcSlice...)
-			continue
+		parts = append(parts, b)
+	}
+	dirs := append([]string{filepath.Join("nginx", "conf.d")}, modules...)
+	for _, dir := range dirs {
+		err := filepath.WalkDir(filepath.Join(cfg.EnvDir, dir), func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(cfg.EnvDir, path)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, []byte(rel), b)
+			return nil
+		})
+		if err != nil {
+			return "", err
 		}
-
-		dst[k] = v
 	}
+	return hashBytes(parts...), nil
 }
 
 func syncModuleAssets(cfg EnvConfig) error {
-	templates := findTemplatesDir()
-	modulesDir := filepath.Join(templates, "modules")
-	entries, err := os.ReadDir(modulesDir)
+	catalog, err := LoadCatalog()
 	if err != nil {
-		return nil
+		return err
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		moduleName := entry.Name()
-		srcDir := filepath.Join(modulesDir, moduleName)
+	for _, moduleName := range catalog.Names() {
+		srcDir := catalog[moduleName].Dir
 		dstDir := filepath.Join(cfg.EnvDir, moduleName)
 
 		err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
@@ -113,10 +248,14 @@ func syncModuleAssets(cfg EnvConfig) error {
 			if rel == "." {
 				return nil
 			}
+			// nginx confs are rendered by writeNginxConfs.
+			if d.IsDir() && rel == "nginx" {
+				return filepath.SkipDir
+			}
 			if d.IsDir() {
 				return ensureDir(filepath.Join(dstDir, rel), 0o750)
 			}
-			if filepath.Base(path) == "compose.yml" {
+			if name := filepath.Base(path); name == "compose.yml" || name == "module.yml" {
 				return nil
 			}
 
@@ -143,27 +282,22 @@ func ComposeBaseArgs(cfg EnvConfig) []string {
 	}
 }
 
+// ComposeServiceExists reports whether the rendered compose file defines
+// service.
 func ComposeServiceExists(cfg EnvConfig, service string) bool {
-	args := ComposeBaseArgs(cfg)
-	args = append(args, "config", "--services")
-	out, err := RunCmdCapture("docker", args...)
+	profiles, err := composeProfiles(cfg)
 	if err != nil {
 		return false
 	}
-	for _, line := range strings.Split(out, "\n") {
-		if strings.TrimSpace(line) == service {
-			return true
-		}
-	}
-	return false
+	_, ok := profiles[service]
+	return ok
 }
 
-func ComposeServiceRunning(cfg EnvConfig, service string) bool {
-	args := ComposeBaseArgs(cfg)
-	args = append(args, "ps", "-q", service)
-	out, err := RunCmdCapture("docker", args...)
-	if err != nil {
-		return false
-	}
-	return strings.TrimSpace(out) != ""
+// ComposeServiceRunning reports whether a container of service is running,
+// asking the Engine API for at most engineTimeout.
+func ComposeServiceRunning(ctx context.Context, cfg EnvConfig, service string) bool {
+	ctx, cancel := context.WithTimeout(ctx, engineTimeout)
+	defer cancel()
+	containers, err := ServiceContainers(ctx, cfg, service, false)
+	return err == nil && len(containers) > 0
 }
@@ -22,6 +22,7 @@ type EnvConfig struct {
 	EnvDir     string
 	Domain     string
 	Email      string
+	Edge       bool
 }
 
 func (cfg EnvConfig) RenderData() RenderData {
@@ -33,13 +34,22 @@ func (cfg EnvConfig) RenderData() RenderData {
 		StackRoot:   cfg.StackRoot,
 		DataRoot:    cfg.DataRoot,
 		BackupRoot:  cfg.BackupRoot,
+		Edge:        cfg.Edge,
+		EdgeNetwork: EdgeNetworkName,
 	}
 }
 
 func LoadEnvConfig(env string) (EnvConfig, error) {
 	env = strings.TrimSpace(env)
-	if env != "dev" && env != "qa" && env != "prod" {
-		return EnvConfig{}, errors.New("--env must be one of: dev, qa, prod")
+	if env == "" {
+		return EnvConfig{}, errors.New("--env is required")
+	}
+	if err := ValidateEnvName(env); err != nil {
+		return EnvConfig{}, err
+	}
+	host, err := LoadHostConfig()
+	if err != nil {
+		return EnvConfig{}, err
 	}
 	stackRoot := GetStackRoot()
 	cfg := EnvConfig{
@@ -48,6 +58,7 @@ func LoadEnvConfig(env string) (EnvConfig, error) {
 		DataRoot:   getDataRoot(),
 		BackupRoot: getBackupRoot(),
 		EnvDir:     filepath.Join(stackRoot, env),
+		Edge:       host.Edge.Enabled,
 	}
 	return cfg, nil
 }
@@ -146,15 +157,31 @@ func WriteDotEnv(path string, vars map[string]string) error {
 	return os.WriteFile(path, []byte(content), 0o640)
 }
 
-func DetectEnvironments() []string {
-	stackRoot := GetStackRoot()
-	envs := []string{}
-	for _, name := range []string{"dev", "qa", "prod"} {
-		if DirExists(filepath.Join(stackRoot, name)) {
-			envs = append(envs, name)
+// IsSecretKey reports whether a .env key holds a password, token or other
+// credential.
+func IsSecretKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, part := range []string{"PASSWORD", "SECRET", "TOKEN", "CREDENTIAL", "PRIVATE"} {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "_KEY") || strings.HasSuffix(key, "_KEY_ID")
+}
+
+// IsDomainKey reports whether a .env key is tied to the environment's own
+// domain or identity rather than to what it runs.
+func IsDomainKey(key string) bool {
+	key = strings.ToUpper(key)
+	if key == "STACK_ENV" {
+		return true
+	}
+	for _, part := range []string{"DOMAIN", "HOST", "EMAIL", "URL"} {
+		if strings.Contains(key, part) {
+			return true
 		}
 	}
-	return envs
+	return false
 }
 
 func GetStackRoot() string {
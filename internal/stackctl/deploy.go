@@ -0,0 +1,217 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+type DeployOptions struct {
+	// Tag is the image tag to roll out.
+	Tag string
+	// WaitTimeout is how long the service gets to become healthy.
+	WaitTimeout time.Duration
+	// Progress is called with the service's state while waiting.
+	Progress func([]ServiceHealth)
+	// BlueGreen starts the new version next to the running one and switches
+	// nginx over once it is healthy, instead of recreating the service.
+	BlueGreen bool
+}
+
+var (
+	// ${BACKEND_IMAGE} or ${BACKEND_IMAGE:-default}: the key holds the whole
+	// image reference.
+	wholeImageVar = regexp.MustCompile(`^\$\{?([A-Za-z_][A-Za-z0-9_]*)(?::?-[^}]*)?\}?$`)
+	// repo:${BACKEND_TAG}: the key holds only the tag.
+	imageTagVar = regexp.MustCompile(`^([^$]+):\$\{?([A-Za-z_][A-Za-z0-9_]*)(?::?-[^}]*)?\}?$`)
+)
+
+// deployTarget works out which .env key sets the image of service and what
+// it has to be set to for tag.
+func deployTarget(raw, tag string, vars map[string]string) (key, value string, err error) {
+	if m := wholeImageVar.FindStringSubmatch(raw); m != nil {
+		current := interpolate(raw, vars)
+		if current == "" {
+			return "", "", fmt.Errorf("%s is not set in .env", m[1])
+		}
+		return m[1], imageRepository(current) + ":" + tag, nil
+	}
+	if m := imageTagVar.FindStringSubmatch(raw); m != nil {
+		return m[2], tag, nil
+	}
+	return "", "", fmt.Errorf("image %q is not set from .env; use image: ${NAME_IMAGE} to deploy it by tag", raw)
+}
+
+// Deploy rolls service out with a new image tag: it updates the service's
+// image key in .env, pulls the image, recreates only that service and waits
+// for it to be healthy. If it does not become healthy the previous tag is
+// put back.
+func Deploy(ctx context.Context, cfg EnvConfig, service string, opts DeployOptions) error {
+	if opts.Tag == "" {
+		return errors.New("--tag is required")
+	}
+	modules, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Deploy only recreates one service, so everything else has to be what
+	// the last apply left running.
+	if err := writeCompose(cfg, modules); err != nil {
+		return err
+	}
+	hash, err := applyHash(cfg, modules)
+	if err != nil {
+		return err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	if st.AppliedHash != hash {
+		return fmt.Errorf("%s has changes that are not applied yet; run stackctl apply --env %s first", cfg.EnvName, cfg.EnvName)
+	}
+
+	ports, err := planPorts(cfg, modules)
+	if err != nil {
+		return err
+	}
+	merged, err := buildCompose(cfg, modules, ports)
+	if err != nil {
+		return err
+	}
+	if opts.BlueGreen {
+		services, err := blueGreenServices(modules)
+		if err != nil {
+			return err
+		}
+		if !contains(services, service) {
+			return fmt.Errorf("%s is not a blue/green service (those are listed under blue_green in module.yml)", service)
+		}
+	}
+	image := mappingValue(mappingValue(mappingValue(merged, "services"), service), "image")
+	if image == nil {
+		return fmt.Errorf("%s has no service %s with an image", cfg.EnvName, service)
+	}
+	envPath := filepath.Join(cfg.EnvDir, ".env")
+	vars, err := dotEnvVars(cfg)
+	if err != nil {
+		return err
+	}
+	key, value, err := deployTarget(image.Value, opts.Tag, vars)
+	if err != nil {
+		return fmt.Errorf("deploy %s: %w", service, err)
+	}
+	oldValue := vars[key]
+	from := interpolate(image.Value, vars)
+	vars[key] = value
+	to := interpolate(image.Value, vars)
+
+	oldLock, err := os.ReadFile(imageLockPath(cfg))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	fmt.Fprintf(Out(ctx), "deploying %s: %s -> %s\n", service, from, to)
+	if err := RunCmdStream(ctx, "docker", "pull", to); err != nil {
+		return fmt.Errorf("docker pull %s: %w", to, err)
+	}
+	if oldLock != nil {
+		lock, err := LoadImageLock(cfg)
+		if err != nil {
+			return err
+		}
+		digest, err := localDigest(ctx, to)
+		if err != nil {
+			return err
+		}
+		if lock.Images == nil {
+			lock.Images = map[string]string{}
+		}
+		lock.Images[to] = digest
+		if err := WriteImageLock(cfg, lock); err != nil {
+			return err
+		}
+	}
+	if err := WriteDotEnv(envPath, map[string]string{key: value}); err != nil {
+		return err
+	}
+
+	var deployErr error
+	if opts.BlueGreen {
+		deployErr = deployBlueGreen(ctx, cfg, modules, service, opts)
+	} else {
+		deployErr = recreateService(ctx, cfg, modules, colorService(service, st.Colors[service]), opts)
+	}
+	if deployErr != nil {
+		fmt.Fprintf(Out(ctx), "deploy failed: %v\nputting %s back to %s\n", deployErr, service, from)
+		if err := restoreDeploy(ctx, cfg, modules, service, envPath, key, oldValue, oldLock, !opts.BlueGreen); err != nil {
+			return fmt.Errorf("%w; restoring %s also failed: %v", deployErr, from, err)
+		}
+		return fmt.Errorf("%w (%s is back on %s)", deployErr, service, from)
+	}
+
+	if hash, err = applyHash(cfg, modules); err != nil {
+		return err
+	}
+	gen, err := markApplied(cfg, modules, hash)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "deployed %s %s to %s (generation %d)\n", service, opts.Tag, cfg.EnvName, gen.Number)
+	return nil
+}
+
+// recreateService renders the compose file and recreates service alone,
+// then waits for it.
+func recreateService(ctx context.Context, cfg EnvConfig, modules []string, service string, opts DeployOptions) error {
+	if err := writeCompose(cfg, modules); err != nil {
+		return err
+	}
+	composeArgs := ComposeBaseArgs(cfg)
+	for _, module := range modules {
+		composeArgs = append(composeArgs, "--profile", module)
+	}
+	composeArgs = append(composeArgs, "up", "-d", "--no-deps", service)
+	if err := RunCmdStream(ctx, "docker", composeArgs...); err != nil {
+		return fmt.Errorf("docker compose up %s: %w", service, err)
+	}
+	if opts.WaitTimeout <= 0 {
+		return nil
+	}
+	fmt.Fprintf(Out(ctx), "waiting up to %s for %s\n", opts.WaitTimeout, service)
+	states, err := WaitHealthy(ctx, cfg, modules, []string{service}, opts.WaitTimeout, opts.Progress)
+	if err != nil {
+		if len(states) > 0 {
+			fmt.Fprint(Out(ctx), HealthTable(states))
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreDeploy puts the previous .env value and images.lock back and, with
+// recreate, recreates service from them.
+func restoreDeploy(ctx context.Context, cfg EnvConfig, modules []string, service, envPath, key, oldValue string, oldLock []byte, recreate bool) error {
+	if err := WriteDotEnv(envPath, map[string]string{key: oldValue}); err != nil {
+		return err
+	}
+	if oldLock != nil {
+		if err := os.WriteFile(imageLockPath(cfg), oldLock, 0o640); err != nil {
+			return err
+		}
+	}
+	if !recreate {
+		return writeCompose(cfg, modules)
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	return recreateService(ctx, cfg, modules, colorService(service, st.Colors[service]), DeployOptions{})
+}
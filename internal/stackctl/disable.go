@@ -0,0 +1,321 @@
+package stackctl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DisableMode says what happens to a module's containers and data when it
+// is disabled.
+type DisableMode int
+
+const (
+	// DisableKeepData only edits enabled.yml; the next apply removes the
+	// containers and the data stays on disk.
+	DisableKeepData DisableMode = iota
+	// DisableArchive removes the containers, tars the data and synced assets
+	// into the backup root and then deletes them.
+	DisableArchive
+	// DisablePurge removes the containers and deletes the data and synced
+	// assets without a copy.
+	DisablePurge
+)
+
+type DisableOptions struct {
+	// Cascade also disables enabled modules that depend on the target.
+	Cascade bool
+	Mode    DisableMode
+	// Confirm is asked before DisablePurge deletes anything; a nil Confirm
+	// or a false answer cancels the disable.
+	Confirm func(modules, paths []string) bool
+}
+
+// DisableModule removes module from enabled.yml, running the disable hooks
+// and cleaning up according to opts.Mode. It returns the modules that are no
+// longer enabled, including dependents and implied dependencies.
+func DisableModule(ctx context.Context, cfg EnvConfig, catalog Catalog, module string, opts DisableOptions) ([]string, error) {
+	current, err := LoadEnabled(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	remove := []string{module}
+	enabled, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if dependents := catalog.EnabledDependents(module, enabled); len(dependents) > 0 {
+		if !opts.Cascade {
+			return nil, fmt.Errorf("cannot disable %s: required by %s (disable those first or pass --cascade)", module, strings.Join(dependents, ", "))
+		}
+		for _, dep := range dependents {
+			fmt.Fprintf(Out(ctx), "also disabling %s (depends on %s)\n", dep, module)
+		}
+		remove = append(remove, dependents...)
+	}
+
+	filtered := make([]string, 0, len(current.Modules))
+	for _, item := range current.Modules {
+		if !contains(remove, item) {
+			filtered = append(filtered, item)
+		}
+	}
+	// Modules whose pack left the search path are only taken out of
+	// enabled.yml; they have no hooks and no known data to clean up.
+	var unknown []string
+	for _, item := range current.Modules {
+		if _, ok := catalog[item]; !ok && contains(remove, item) {
+			unknown = append(unknown, item)
+		}
+	}
+	disabled := RemovedModules(catalog, current.Modules, filtered)
+	wasEnabled := len(filtered) < len(current.Modules)
+	if !wasEnabled {
+		if opts.Mode == DisableKeepData {
+			return nil, nil
+		}
+		// Cleaning up an already disabled module reclaims the data an
+		// earlier plain disable left behind.
+		disabled = []string{module}
+	}
+	if opts.Mode != DisableKeepData {
+		for _, name := range unknown {
+			fmt.Fprintf(Out(ctx), "skipping the cleanup of %s: it is not in the module search path\n", name)
+		}
+	}
+
+	paths := moduleDataPaths(cfg, catalog, disabled)
+	if opts.Mode == DisablePurge && len(disabled) > 0 && (opts.Confirm == nil || !opts.Confirm(disabled, paths)) {
+		return nil, errors.New("purge cancelled")
+	}
+
+	if wasEnabled {
+		if err := RunModuleHooks(ctx, cfg, HookPreDisable, disabled, current.Params); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Mode != DisableKeepData {
+		if err := removeModuleContainers(ctx, cfg, disabled); err != nil {
+			return nil, err
+		}
+		if opts.Mode == DisableArchive {
+			archive, err := archiveModuleData(cfg, disabled, paths)
+			if err != nil {
+				return nil, err
+			}
+			if archive != "" {
+				fmt.Fprintf(Out(ctx), "archived %s data to %s\n", strings.Join(disabled, ", "), archive)
+			}
+		}
+		for _, p := range paths {
+			if err := os.RemoveAll(p); err != nil {
+				return nil, fmt.Errorf("remove %s: %w", p, err)
+			}
+			fmt.Fprintf(Out(ctx), "removed %s\n", p)
+		}
+	}
+
+	if !wasEnabled {
+		return disabled, nil
+	}
+	params := maps.Clone(current.Params)
+	current.SetModules(filtered)
+	if err := WriteEnabled(cfg, current); err != nil {
+		return nil, err
+	}
+	if err := RunModuleHooks(ctx, cfg, HookPostDisable, disabled, params); err != nil {
+		return nil, err
+	}
+	return append(disabled, unknown...), nil
+}
+
+// moduleDataPaths lists the existing data directories and synced asset
+// directories of modules. Only paths below the environment's data and
+// environment directories are listed, so a purge cannot reach anything else.
+func moduleDataPaths(cfg EnvConfig, catalog Catalog, modules []string) []string {
+	dataDir := filepath.Join(cfg.DataRoot, cfg.EnvName)
+	var paths []string
+	add := func(root, p string) {
+		if pathWithin(root, p) && DirExists(p) && !contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	for _, name := range modules {
+		dirs := []string{name}
+		if info, ok := catalog[name]; ok && len(info.DataDirs) > 0 {
+			dirs = info.DataDirs
+		}
+		if !contains(reservedEnvDirs, name) {
+			add(cfg.EnvDir, filepath.Join(cfg.EnvDir, name))
+		}
+		for _, d := range dirs {
+			add(dataDir, filepath.Join(dataDir, d))
+		}
+	}
+	return paths
+}
+
+// composeProfiles returns the services of the rendered compose file with
+// their profiles.
+func composeProfiles(cfg EnvConfig) (map[string][]string, error) {
+	b, err := os.ReadFile(filepath.Join(cfg.EnvDir, "compose.yml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Services map[string]struct {
+			Profiles []string `yaml:"profiles"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse compose.yml: %w", err)
+	}
+	profiles := map[string][]string{}
+	for name, svc := range doc.Services {
+		profiles[name] = svc.Profiles
+	}
+	return profiles, nil
+}
+
+// profileServices returns the services of the rendered compose file that
+// belong to module's profile.
+func profileServices(cfg EnvConfig, module string) ([]string, error) {
+	profiles, err := composeProfiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	for _, name := range sortedKeys(profiles) {
+		if contains(profiles[name], module) {
+			services = append(services, name)
+		}
+	}
+	return services, nil
+}
+
+func removeModuleContainers(ctx context.Context, cfg EnvConfig, modules []string) error {
+	for _, module := range modules {
+		services, err := profileServices(cfg, module)
+		if err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			continue
+		}
+		fmt.Fprintf(Out(ctx), "removing %s containers: %s\n", module, strings.Join(services, ", "))
+		args := append(ComposeBaseArgs(cfg), "--profile", module, "rm", "--stop", "--force")
+		args = append(args, services...)
+		if err := RunCmdStream(ctx, "docker", args...); err != nil {
+			return fmt.Errorf("remove %s containers: %w", module, err)
+		}
+	}
+	return nil
+}
+
+// archiveModuleData writes paths to a tar.gz in the environment's backup
+// directory. Entries are named after their location relative to the stack
+// and data roots.
+func archiveModuleData(cfg EnvConfig, modules, paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", nil
+	}
+	backupDir := filepath.Join(cfg.BackupRoot, cfg.EnvName)
+	if err := ensureDir(backupDir, 0o750); err != nil {
+		return "", err
+	}
+	ts := time.Now().UTC().Format("20060102T150405Z")
+	target := filepath.Join(backupDir, fmt.Sprintf("module_%s_%s.tar.gz", strings.Join(modules, "+"), ts))
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", fmt.Errorf("create archive: %w", err)
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, p := range paths {
+		prefix := "data"
+		base := filepath.Join(cfg.DataRoot, cfg.EnvName)
+		if strings.HasPrefix(p, cfg.EnvDir+string(filepath.Separator)) {
+			prefix, base = "stack", cfg.EnvDir
+		}
+		if err := addToTar(tw, p, base, prefix); err != nil {
+			out.Close()
+			os.Remove(target)
+			return "", fmt.Errorf("archive %s: %w", p, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", err
+	}
+	return target, out.Close()
+}
+
+func addToTar(tw *tar.Writer, root, base, prefix string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
@@ -0,0 +1,70 @@
+package stackctl
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisableModuleNotInCatalog(t *testing.T) {
+	for _, mode := range []DisableMode{DisableKeepData, DisablePurge} {
+		cfg := newTestEnv(t, map[string]string{
+			"enabled.yml": "version: 2\nmodules:\n  - postgres\n  - gone:\n      size: big\n",
+		})
+		catalog, err := LoadCatalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &RecordingRunner{}
+		ctx := WithRunner(context.Background(), r)
+
+		disabled, err := DisableModule(ctx, cfg, catalog, "gone", DisableOptions{Mode: mode})
+		if err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		if !reflect.DeepEqual(disabled, []string{"gone"}) {
+			t.Errorf("mode %d: disabled = %v, want [gone]", mode, disabled)
+		}
+		conf, err := LoadEnabled(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(conf.Modules, []string{"postgres"}) || conf.Params["gone"] != nil {
+			t.Errorf("mode %d: enabled.yml = %v %v, want only postgres", mode, conf.Modules, conf.Params)
+		}
+		if cmds := r.Commands(); len(cmds) > 0 {
+			t.Errorf("mode %d: ran %v, want nothing for a module without a manifest", mode, cmds)
+		}
+		if skipped := strings.Contains(r.Printed(), "skipping the cleanup of gone"); skipped != (mode == DisablePurge) {
+			t.Errorf("mode %d: printed %q", mode, r.Printed())
+		}
+	}
+}
+
+func TestDisableModuleDependents(t *testing.T) {
+	cfg := newTestEnv(t, map[string]string{
+		"enabled.yml": "version: 2\nmodules:\n  - postgres\n  - keycloak\n",
+	})
+	catalog, err := LoadCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RecordingRunner{}
+	ctx := WithRunner(context.Background(), r)
+
+	if _, err := DisableModule(ctx, cfg, catalog, "postgres", DisableOptions{}); err == nil || !strings.Contains(err.Error(), "required by keycloak") {
+		t.Fatalf("err = %v, want postgres to be required by keycloak", err)
+	}
+	disabled, err := DisableModule(ctx, cfg, catalog, "postgres", DisableOptions{Cascade: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(disabled, " ") != "keycloak postgres" && strings.Join(disabled, " ") != "postgres keycloak" {
+		t.Errorf("disabled = %v, want postgres and keycloak", disabled)
+	}
+	if got := readTestFile(t, filepath.Join(cfg.EnvDir, "enabled.yml")); strings.Contains(got, "postgres") || strings.Contains(got, "keycloak") {
+		t.Errorf("enabled.yml still lists them:\n%s", got)
+	}
+}
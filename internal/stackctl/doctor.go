@@ -1,12 +1,15 @@
 package stackctl
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
 	"syscall"
+
+	"github.com/example/stackctl/internal/engine"
 )
 
 type CheckResult struct {
@@ -15,7 +18,7 @@ type CheckResult struct {
 	Err  error
 }
 
-func RunChecks() []CheckResult {
+func RunChecks(ctx context.Context) []CheckResult {
 	checks := []struct {
 		name string
 		fn   func() error
@@ -25,12 +28,17 @@ func RunChecks() []CheckResult {
 			return err
 		}},
 		{"docker compose", func() error {
-			_, err := RunCmdCapture("docker", "compose", "version")
+			_, err := RunCmdCapture(ctx, "docker", "compose", "version")
 			return err
 		}},
-		{"docker daemon", func() error {
-			_, err := RunCmdCapture("docker", "info")
-			return err
+		{"docker engine API", func() error {
+			client, err := engine.FromEnv()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(ctx, engineTimeout)
+			defer cancel()
+			return client.Ping(ctx)
 		}},
 		{"/srv/stack writable", func() error {
 			return writableCheck(GetStackRoot())
@@ -42,7 +50,7 @@ func RunChecks() []CheckResult {
 			return diskCheck("/srv", 5)
 		}},
 		{"ports 80/443 status", func() error {
-			out, err := RunCmdCapture("ss", "-ltn")
+			out, err := RunCmdCapture(ctx, "ss", "-ltn")
 			if err != nil {
 				return err
 			}
@@ -65,16 +73,16 @@ func RunChecks() []CheckResult {
 	return results
 }
 
-func RunDoctor() error {
-	fmt.Println("stackctl doctor")
-	fmt.Printf("runtime: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+func RunDoctor(ctx context.Context) error {
+	fmt.Fprintln(Out(ctx), "stackctl doctor")
+	fmt.Fprintf(Out(ctx), "runtime: %s/%s\n", runtime.GOOS, runtime.GOARCH)
 
-	results := RunChecks()
+	results := RunChecks(ctx)
 	for _, r := range results {
 		if r.OK {
-			fmt.Printf("[ OK ] %s\n", r.Name)
+			fmt.Fprintf(Out(ctx), "[ OK ] %s\n", r.Name)
 		} else {
-			fmt.Printf("[WARN] %s: %v\n", r.Name, r.Err)
+			fmt.Fprintf(Out(ctx), "[WARN] %s: %v\n", r.Name, r.Err)
 		}
 	}
 	return nil
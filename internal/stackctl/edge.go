@@ -0,0 +1,290 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	edgeDirName     = "edge"
+	edgeProjectName = "stackctl-edge"
+	EdgeNetworkName = "stackctl_edge"
+)
+
+type edgeRenderData struct {
+	Project string
+	Dir     string
+	Network string
+}
+
+type edgeRouteData struct {
+	Env      string
+	Domain   string
+	Upstream string
+}
+
+func edgeDir() string {
+	return filepath.Join(GetStackRoot(), edgeDirName)
+}
+
+func edgeComposeArgs() []string {
+	return []string{
+		"compose",
+		"-f", filepath.Join(edgeDir(), "compose.yml"),
+		"-p", edgeProjectName,
+	}
+}
+
+func cmdEdge(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("edge subcommand is required: init, apply, status or disable")
+	}
+
+	switch args[0] {
+	case "init":
+		return edgeInit(ctx)
+	case "apply":
+		return edgeApply(ctx)
+	case "status":
+		return edgeStatus(ctx)
+	case "disable":
+		return edgeDisable(ctx)
+	default:
+		return fmt.Errorf("unknown edge subcommand: %s", args[0])
+	}
+}
+
+func edgeInit(ctx context.Context) error {
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return err
+	}
+	conf.Edge.Enabled = true
+	if err := WriteHostConfig(conf); err != nil {
+		return err
+	}
+	if err := writeEdgeFiles(); err != nil {
+		return err
+	}
+	if err := ensureEdgeNetwork(ctx); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Out(ctx), "initialized edge proxy at %s\n", edgeDir())
+	fmt.Fprintln(Out(ctx), "next: re-apply every environment so its nginx stops publishing 80/443:")
+	for _, env := range DetectEnvironments() {
+		fmt.Fprintf(Out(ctx), "  stackctl apply --env %s\n", env)
+	}
+	fmt.Fprintln(Out(ctx), "then: stackctl edge apply")
+	return nil
+}
+
+func edgeApply(ctx context.Context) error {
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return err
+	}
+	if !conf.Edge.Enabled {
+		return errors.New("edge proxy is not enabled; run: stackctl edge init")
+	}
+	if err := writeEdgeFiles(); err != nil {
+		return err
+	}
+	if err := ensureEdgeNetwork(ctx); err != nil {
+		return err
+	}
+
+	for _, env := range DetectEnvironments() {
+		cfg, err := LoadEnvConfig(env)
+		if err != nil {
+			return err
+		}
+		if !composeUsesEdge(cfg) {
+			fmt.Fprintf(Out(ctx), "warning: %s still publishes 80/443; run: stackctl apply --env %s\n", env, env)
+		}
+	}
+
+	args := append(edgeComposeArgs(), "up", "-d", "--remove-orphans")
+	if err := RunCmdStream(ctx, "docker", args...); err != nil {
+		return err
+	}
+	fmt.Fprintln(Out(ctx), "applied edge proxy")
+	return nil
+}
+
+func edgeStatus(ctx context.Context) error {
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return err
+	}
+	if !conf.Edge.Enabled {
+		fmt.Fprintln(Out(ctx), "edge proxy: disabled")
+		return nil
+	}
+
+	fmt.Fprintln(Out(ctx), "edge proxy: enabled")
+	fmt.Fprintf(Out(ctx), "path: %s\n", edgeDir())
+	fmt.Fprintf(Out(ctx), "network: %s\n", EdgeNetworkName)
+	fmt.Fprintln(Out(ctx), "routes:")
+	for _, route := range edgeRoutes() {
+		fmt.Fprintf(Out(ctx), "  - %-14s .%s -> %s\n", route.Env, route.Domain, route.Upstream)
+	}
+
+	args := append(edgeComposeArgs(), "ps")
+	output, cmdErr := RunCmdCapture(ctx, "docker", args...)
+	if cmdErr != nil {
+		fmt.Fprintln(Out(ctx), "docker compose status unavailable:")
+		fmt.Fprintln(Out(ctx), strings.TrimSpace(output))
+		return nil
+	}
+	fmt.Fprintln(Out(ctx), output)
+	return nil
+}
+
+func edgeDisable(ctx context.Context) error {
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return err
+	}
+	if !conf.Edge.Enabled {
+		fmt.Fprintln(Out(ctx), "edge proxy already disabled")
+		return nil
+	}
+	conf.Edge.Enabled = false
+	if err := WriteHostConfig(conf); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(edgeDir(), "compose.yml")); err == nil {
+		args := append(edgeComposeArgs(), "down")
+		if err := RunCmdStream(ctx, "docker", args...); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(Out(ctx), "edge proxy disabled")
+	fmt.Fprintln(Out(ctx), "next: re-apply environments so nginx publishes 80/443 again (only one can own them):")
+	for _, env := range DetectEnvironments() {
+		fmt.Fprintf(Out(ctx), "  stackctl apply --env %s\n", env)
+	}
+	return nil
+}
+
+func edgeRoutes() []edgeRouteData {
+	var routes []edgeRouteData
+	for _, env := range DetectEnvironments() {
+		cfg, err := LoadEnvConfig(env)
+		if err != nil {
+			continue
+		}
+		if err := HydrateFromDotEnv(&cfg); err != nil || cfg.Domain == "" {
+			continue
+		}
+		routes = append(routes, edgeRouteData{
+			Env:      cfg.EnvName,
+			Domain:   cfg.Domain,
+			Upstream: cfg.EnvName + "-nginx",
+		})
+	}
+	return routes
+}
+
+func writeEdgeFiles() error {
+	templates := findTemplatesDir()
+	dir := edgeDir()
+	confDir := filepath.Join(dir, "conf.d")
+	if err := ensureDir(confDir, 0o750); err != nil {
+		return err
+	}
+
+	data := edgeRenderData{
+		Project: edgeProjectName,
+		Dir:     dir,
+		Network: EdgeNetworkName,
+	}
+	text, err := renderFile(filepath.Join(templates, "edge", "compose.yml"), data)
+	if err != nil {
+		return fmt.Errorf("render edge compose: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "compose.yml"), []byte(text), 0o640); err != nil {
+		return err
+	}
+
+	def, err := os.ReadFile(filepath.Join(templates, "edge", "default.conf"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "default.conf"), def, 0o640); err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{"default.conf": true}
+	for _, route := range edgeRoutes() {
+		text, err := renderFile(filepath.Join(templates, "edge", "route.conf"), route)
+		if err != nil {
+			return fmt.Errorf("render edge route for %s: %w", route.Env, err)
+		}
+		name := "env-" + route.Env + ".conf"
+		wanted[name] = true
+		if err := os.WriteFile(filepath.Join(confDir, name), []byte(text), 0o640); err != nil {
+			return err
+		}
+	}
+
+	// Drop routes for environments that no longer exist.
+	entries, err := os.ReadDir(confDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !wanted[entry.Name()] && strings.HasPrefix(entry.Name(), "env-") {
+			_ = os.Remove(filepath.Join(confDir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+func ensureEdgeNetwork(ctx context.Context) error {
+	if _, err := RunCmdCapture(ctx, "docker", "network", "inspect", EdgeNetworkName); err == nil {
+		return nil
+	}
+	out, err := RunCmdCapture(ctx, "docker", "network", "create", EdgeNetworkName)
+	if err != nil {
+		if msg := strings.TrimSpace(out); msg != "" {
+			return fmt.Errorf("create network %s: %s", EdgeNetworkName, msg)
+		}
+		return fmt.Errorf("create network %s: %w", EdgeNetworkName, err)
+	}
+	return nil
+}
+
+// refreshEdgeRoutes re-renders the edge routes after an environment apply and
+// reloads the edge nginx when it is running.
+func refreshEdgeRoutes(ctx context.Context) error {
+	if err := writeEdgeFiles(); err != nil {
+		return err
+	}
+	args := append(edgeComposeArgs(), "ps", "-q", "edge")
+	out, err := RunCmdCapture(ctx, "docker", args...)
+	if err != nil || strings.TrimSpace(out) == "" {
+		fmt.Fprintln(Out(ctx), "edge proxy is not running; start it with: stackctl edge apply")
+		return nil
+	}
+	args = append(edgeComposeArgs(), "exec", "-T", "edge", "nginx", "-s", "reload")
+	if out, err := RunCmdCapture(ctx, "docker", args...); err != nil {
+		return fmt.Errorf("reload edge proxy: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func composeUsesEdge(cfg EnvConfig) bool {
+	b, err := os.ReadFile(filepath.Join(cfg.EnvDir, "compose.yml"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(b), EdgeNetworkName)
+}
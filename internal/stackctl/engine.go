@@ -0,0 +1,85 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/stackctl/internal/engine"
+)
+
+const (
+	// engineTimeout bounds the quick Engine API reads, such as listing
+	// containers.
+	engineTimeout = 10 * time.Second
+	// proxyPingTimeout is how long the environment's socket-proxy gets to
+	// answer before the local socket is used instead.
+	proxyPingTimeout = 2 * time.Second
+)
+
+// EngineClient returns a Docker Engine API client for cfg. It goes through
+// the environment's socket-proxy when that is enabled and answering, and
+// through DOCKER_HOST or the local socket otherwise.
+func EngineClient(ctx context.Context, cfg EnvConfig) (*engine.Client, error) {
+	if st, err := LoadState(cfg); err == nil {
+		if port := st.Ports["socket-proxy"]["api"]; port != 0 {
+			proxy, err := engine.New(fmt.Sprintf("tcp://%s:%d", LoopbackHost, port))
+			if err == nil {
+				pingCtx, cancel := context.WithTimeout(ctx, proxyPingTimeout)
+				err = proxy.Ping(pingCtx)
+				cancel()
+			}
+			if err == nil {
+				return proxy, nil
+			}
+		}
+	}
+	return engine.FromEnv()
+}
+
+// ServiceContainers lists the containers of service in cfg's compose
+// project; with all also those that are not running.
+func ServiceContainers(ctx context.Context, cfg EnvConfig, service string, all bool) ([]engine.Container, error) {
+	client, err := EngineClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListContainers(ctx, engine.ListOptions{Project: cfg.EnvName, Service: service, All: all})
+}
+
+// ServiceLogs prints the log of service's containers. For a blue/green
+// service that is the active color.
+func ServiceLogs(ctx context.Context, cfg EnvConfig, service string, opts engine.LogsOptions) error {
+	if st, err := LoadState(cfg); err == nil {
+		service = colorService(service, st.Colors[service])
+	}
+	client, err := EngineClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	containers, err := client.ListContainers(ctx, engine.ListOptions{Project: cfg.EnvName, Service: service, All: true})
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("%s has no container for %s", cfg.EnvName, service)
+	}
+
+	// Replicas are followed side by side.
+	errs := make(chan error, len(containers))
+	for _, c := range containers {
+		go func() {
+			if err := client.Logs(ctx, c.ID, opts, Out(ctx), ErrOut(ctx)); err != nil {
+				errs <- fmt.Errorf("%s: %w", c.Name(), err)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	var logErr error
+	for range containers {
+		logErr = errors.Join(logErr, <-errs)
+	}
+	return logErr
+}
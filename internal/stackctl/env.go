@@ -0,0 +1,98 @@
+package stackctl
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// envNameRegex follows the Compose project-name rules: lowercase letters,
+// digits, dashes and underscores, starting with a letter or digit.
+var envNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// DefaultEnvironments are offered by the setup wizard and are always probed
+// on disk so hosts initialized before the registry existed keep working.
+var DefaultEnvironments = []string{"dev", "qa", "prod"}
+
+// reservedEnvNames are directories under the stack root owned by stackctl
+// itself.
+var reservedEnvNames = []string{edgeDirName}
+
+func ValidateEnvName(name string) error {
+	if name == "" {
+		return fmt.Errorf("environment name is required")
+	}
+	if !envNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid environment name %q: use lowercase letters, digits, '-' and '_', starting with a letter or digit", name)
+	}
+	if contains(reservedEnvNames, name) {
+		return fmt.Errorf("environment name %q is reserved", name)
+	}
+	return nil
+}
+
+// RegisterEnvironment records name in the host environment registry.
+func RegisterEnvironment(name string) error {
+	if err := ValidateEnvName(name); err != nil {
+		return err
+	}
+	conf, err := LoadHostConfig()
+	if err != nil {
+		return err
+	}
+	if contains(conf.Environments, name) {
+		return nil
+	}
+	conf.Environments = append(conf.Environments, name)
+	sort.Strings(conf.Environments)
+	return WriteHostConfig(conf)
+}
+
+// RegisteredEnvironments returns every known environment name, whether or
+// not it has been initialized on disk yet.
+func RegisteredEnvironments() []string {
+	names := append([]string{}, DefaultEnvironments...)
+	if conf, err := LoadHostConfig(); err == nil {
+		for _, name := range conf.Environments {
+			if ValidateEnvName(name) == nil && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sortEnvNames(names)
+	return names
+}
+
+// DetectEnvironments returns the registered environments that exist under
+// the stack root.
+func DetectEnvironments() []string {
+	stackRoot := GetStackRoot()
+	envs := []string{}
+	for _, name := range RegisteredEnvironments() {
+		if DirExists(filepath.Join(stackRoot, name)) {
+			envs = append(envs, name)
+		}
+	}
+	return envs
+}
+
+// sortEnvNames keeps the default environments first, in their usual
+// dev/qa/prod order, followed by the remaining names alphabetically.
+func sortEnvNames(names []string) {
+	rank := func(name string) int {
+		for i, def := range DefaultEnvironments {
+			if def == name {
+				return i
+			}
+		}
+		return len(DefaultEnvironments)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ri, rj := rank(names[i]), rank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+}
@@ -0,0 +1,114 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envGitignore keeps secrets and stackctl's own bookkeeping out of an
+// environment's git repository. .env is covered by the generations instead.
+const envGitignore = `# Written by stackctl config track.
+.env
+.generations/
+.stackctl.lock
+history.log
+state.yml
+`
+
+// EnvConfigTracked reports whether the environment dir is a git repository
+// stackctl commits to.
+func EnvConfigTracked(cfg EnvConfig) bool {
+	return DirExists(filepath.Join(cfg.EnvDir, ".git"))
+}
+
+// envGit runs git in the environment dir as whoever runs stackctl, which is
+// not always the owner of the repository.
+func envGit(ctx context.Context, cfg EnvConfig, args ...string) (string, error) {
+	full := append([]string{"-C", cfg.EnvDir, "-c", "safe.directory=" + cfg.EnvDir}, args...)
+	out, err := RunCmdOutput(ctx, "git", full...)
+	if err != nil {
+		return out, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// TrackEnvConfig turns the environment dir into a git repository and commits
+// its current files.
+func TrackEnvConfig(ctx context.Context, cfg EnvConfig) error {
+	if EnvConfigTracked(cfg) {
+		return fmt.Errorf("%s is already tracked in git", cfg.EnvDir)
+	}
+	if !DirExists(cfg.EnvDir) {
+		return fmt.Errorf("%s does not exist (run stackctl init --env %s first)", cfg.EnvDir, cfg.EnvName)
+	}
+	if _, err := envGit(ctx, cfg, "init", "-q"); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(cfg.EnvDir, ".gitignore"), []byte(envGitignore), 0o640); err != nil {
+		return err
+	}
+	return commitEnvConfig(ctx, cfg, "stackctl config track --env "+cfg.EnvName, nil)
+}
+
+// commitEnvConfig commits every change in the environment dir, if there is
+// any, as the user running stackctl.
+func commitEnvConfig(ctx context.Context, cfg EnvConfig, subject string, body []string) error {
+	if _, err := envGit(ctx, cfg, "add", "-A"); err != nil {
+		return err
+	}
+	if _, err := envGit(ctx, cfg, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	name := currentUser()
+	if name == "" {
+		name = "stackctl"
+	}
+	host, _ := os.Hostname()
+	message := subject
+	if len(body) > 0 {
+		message += "\n\n" + strings.Join(body, "\n")
+	}
+	_, err := envGit(ctx, cfg, "-c", "user.name="+name, "-c", "user.email="+name+"@"+host, "commit", "-q", "-m", message)
+	return err
+}
+
+// ConfigLog prints the commits of the environment's config.
+func ConfigLog(ctx context.Context, cfg EnvConfig, limit int, patch bool) error {
+	if !EnvConfigTracked(cfg) {
+		return notTracked(cfg)
+	}
+	args := []string{"-C", cfg.EnvDir, "-c", "safe.directory=" + cfg.EnvDir, "log", "--date=format:%Y-%m-%d %H:%M:%S", "--format=%h  %ad  %<(10,trunc)%an %s"}
+	if limit > 0 {
+		args = append(args, fmt.Sprintf("-%d", limit))
+	}
+	if patch {
+		args = append(args, "--stat", "-p")
+	}
+	return RunCmdStream(ctx, "git", args...)
+}
+
+// RevertEnvConfig puts the tracked files of the environment dir back to how
+// they were at rev. The commit is made by the command's run; nothing is
+// applied.
+func RevertEnvConfig(ctx context.Context, cfg EnvConfig, rev string) error {
+	if !EnvConfigTracked(cfg) {
+		return notTracked(cfg)
+	}
+	if _, err := envGit(ctx, cfg, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return fmt.Errorf("%s is not a commit of %s (see stackctl config log --env %s)", rev, cfg.EnvDir, cfg.EnvName)
+	}
+	if _, err := envGit(ctx, cfg, "restore", "--source="+rev, "--staged", "--worktree", "--", "."); err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "restored the config of %s as of %s\n", cfg.EnvName, rev)
+	fmt.Fprintf(Out(ctx), "run: stackctl plan --env %s && stackctl apply --env %s\n", cfg.EnvName, cfg.EnvName)
+	return nil
+}
+
+func notTracked(cfg EnvConfig) error {
+	return errors.New(cfg.EnvName + " is not tracked in git; turn it on with: stackctl config track --env " + cfg.EnvName)
+}
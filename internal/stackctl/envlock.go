@@ -0,0 +1,120 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envLockFile      = ".stackctl.lock"
+	lockPollInterval = 500 * time.Millisecond
+)
+
+// ErrEnvLocked is returned by LockEnv when another run holds the lock.
+var ErrEnvLocked = errors.New("locked")
+
+// EnvLockInfo is the owner a run records in the environment's lock file.
+type EnvLockInfo struct {
+	PID     int       `yaml:"pid"`
+	Command string    `yaml:"command"`
+	Started time.Time `yaml:"started"`
+}
+
+func (l EnvLockInfo) String() string {
+	if l.PID == 0 {
+		return "another stackctl run"
+	}
+	return fmt.Sprintf("pid %d (%s) since %s", l.PID, l.Command, l.Started.Local().Format("15:04:05"))
+}
+
+func envLockPath(cfg EnvConfig) string {
+	return filepath.Join(cfg.EnvDir, envLockFile)
+}
+
+// LockEnv takes the environment's advisory lock for command, waiting up to
+// wait for another run to release it. The returned func releases it. An
+// environment that is not initialized yet has nothing to lock.
+func LockEnv(ctx context.Context, cfg EnvConfig, command string, wait time.Duration) (func(), error) {
+	if !DirExists(cfg.EnvDir) {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(envLockPath(cfg), os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open lock: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	announced := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", cfg.EnvName, err)
+		}
+		holder := readLockInfo(f)
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s is %w by %s", cfg.EnvName, ErrEnvLocked, holder)
+		}
+		if !announced {
+			fmt.Fprintf(Out(ctx), "waiting up to %s for %s, held by %s\n", wait, cfg.EnvName, holder)
+			announced = true
+		}
+		if err := sleep(ctx, lockPollInterval); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	info, err := yaml.Marshal(EnvLockInfo{PID: os.Getpid(), Command: command, Started: time.Now().Truncate(time.Second)})
+	if err == nil {
+		if err = f.Truncate(0); err == nil {
+			_, err = f.WriteAt(info, 0)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("record lock owner: %w", err)
+	}
+	return func() {
+		f.Truncate(0)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func readLockInfo(f *os.File) EnvLockInfo {
+	var info EnvLockInfo
+	if b, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<16)); err == nil {
+		_ = yaml.Unmarshal(b, &info)
+	}
+	return info
+}
+
+// EnvLockHolder reports who holds the environment's lock, if anyone.
+func EnvLockHolder(cfg EnvConfig) (EnvLockInfo, bool) {
+	f, err := os.Open(envLockPath(cfg))
+	if err != nil {
+		return EnvLockInfo{}, false
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return EnvLockInfo{}, false
+	}
+	return readLockInfo(f), true
+}
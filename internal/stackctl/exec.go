@@ -1,19 +1,309 @@
 package stackctl
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-func RunCmdCapture(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	return string(out), err
+// Cmd is an external command for a Runner.
+type Cmd struct {
+	Name string
+	Args []string
+	// Env is added to stackctl's own environment.
+	Env []string
+	Dir string
+	// Stdout and Stderr left nil go to the runner's Output and ErrOutput.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
-func RunCmdStream(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func (c *Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs stackctl's external commands. The one in the context is used
+// for everything a command does, so it decides where output goes and whether
+// anything runs at all.
+type Runner interface {
+	// Run runs cmd and waits for it; cancelling ctx interrupts it.
+	Run(ctx context.Context, cmd *Cmd) error
+	// Output is where commands stream to and stackctl prints its progress.
+	Output() io.Writer
+	// ErrOutput is where the error output of commands goes, and of what
+	// stackctl streams itself, such as container logs.
+	ErrOutput() io.Writer
+}
+
+type runnerKey struct{}
+
+// WithRunner returns a context whose commands run through r.
+func WithRunner(ctx context.Context, r Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, r)
+}
+
+// RunnerFrom returns the runner of ctx: the one set with WithRunner, or one
+// running commands on the terminal.
+func RunnerFrom(ctx context.Context) Runner {
+	if r, ok := ctx.Value(runnerKey{}).(Runner); ok {
+		return r
+	}
+	return &ExecRunner{Out: os.Stdout, Err: os.Stderr}
+}
+
+// Out is where stackctl prints progress in ctx.
+func Out(ctx context.Context) io.Writer {
+	return RunnerFrom(ctx).Output()
+}
+
+// ErrOut is where error output goes in ctx.
+func ErrOut(ctx context.Context) io.Writer {
+	return RunnerFrom(ctx).ErrOutput()
+}
+
+type warningsKey struct{}
+
+// warnings remembers the warnings printed during one run.
+type warnings struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// withWarnings returns a context in which warnOnce prints each warning only
+// once.
+func withWarnings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, warningsKey{}, &warnings{seen: map[string]bool{}})
+}
+
+// warnOnce prints a warning to ErrOut(ctx) unless the run already printed
+// the same one.
+func warnOnce(ctx context.Context, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if w, ok := ctx.Value(warningsKey{}).(*warnings); ok {
+		w.mu.Lock()
+		seen := w.seen[msg]
+		w.seen[msg] = true
+		w.mu.Unlock()
+		if seen {
+			return
+		}
+	}
+	fmt.Fprintf(ErrOut(ctx), "warning: %s\n", msg)
+}
+
+// interruptGrace is how long an interrupted command gets to exit before it
+// is killed.
+const interruptGrace = 10 * time.Second
+
+// ExecRunner runs commands for real. Err is the stderr of commands that do
+// not set their own; nil means Out.
+type ExecRunner struct {
+	Out io.Writer
+	Err io.Writer
+}
+
+func (r *ExecRunner) Run(ctx context.Context, c *Cmd) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	// Commands get their own process group so that a Ctrl-C reaches them
+	// once, from here, and they wind down the way they do on Ctrl-C.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGINT) }
+	cmd.WaitDelay = interruptGrace
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = r.Out
+	}
+	cmd.Stderr = c.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = r.ErrOutput()
+	}
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", c.Name, ctx.Err())
+	}
+	return err
+}
+
+func (r *ExecRunner) Output() io.Writer {
+	return r.Out
+}
+
+func (r *ExecRunner) ErrOutput() io.Writer {
+	if r.Err == nil {
+		return r.Out
+	}
+	return r.Err
+}
+
+// CaptureRunner runs commands for real and keeps everything they and
+// stackctl print, for callers such as the TUI that own the terminal.
+type CaptureRunner struct {
+	ExecRunner
+	buf lockedBuffer
+}
+
+func NewCaptureRunner() *CaptureRunner {
+	r := &CaptureRunner{}
+	r.ExecRunner.Out = &r.buf
+	return r
+}
+
+// String returns what was printed so far.
+func (r *CaptureRunner) String() string {
+	return r.buf.String()
+}
+
+// lockedBuffer is a bytes.Buffer commands may write to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// VerboseRunner prints every command to Log before Runner runs it, with the
+// values of secrets redacted.
+type VerboseRunner struct {
+	Runner
+	Log io.Writer
+}
+
+func (r *VerboseRunner) Run(ctx context.Context, c *Cmd) error {
+	fmt.Fprintf(r.Log, "+ %s\n", redactCommand(c))
+	return r.Runner.Run(ctx, c)
+}
+
+// redactCommand formats c for display. KEY=value words and environment
+// entries with a secret key and passwords in URLs are redacted.
+func redactCommand(c *Cmd) string {
+	var words []string
+	for _, kv := range c.Env {
+		words = append(words, redactWord(kv))
+	}
+	words = append(words, c.Name)
+	for _, arg := range c.Args {
+		words = append(words, redactWord(arg))
+	}
+	for i, w := range words {
+		if w == "" || strings.ContainsAny(w, " \t\n\"'$`\\") {
+			words[i] = fmt.Sprintf("%q", w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func redactWord(word string) string {
+	if key, _, ok := strings.Cut(word, "="); ok && IsSecretKey(key) {
+		return key + "=" + redacted
+	}
+	if u, err := url.Parse(word); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return strings.Replace(word, u.User.String()+"@", u.User.Username()+":"+redacted+"@", 1)
+		}
+	}
+	return word
+}
+
+// RecordingRunner records the commands it is given instead of running them,
+// for tests. Respond, if set, makes up each command's stdout and error.
+type RecordingRunner struct {
+	Respond func(cmd Cmd) (string, error)
+
+	mu       sync.Mutex
+	commands []Cmd
+	out      lockedBuffer
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, c *Cmd) error {
+	r.mu.Lock()
+	r.commands = append(r.commands, *c)
+	r.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Respond == nil {
+		return nil
+	}
+	out, err := r.Respond(*c)
+	w := c.Stdout
+	if w == nil {
+		w = &r.out
+	}
+	io.WriteString(w, out)
+	return err
+}
+
+func (r *RecordingRunner) Output() io.Writer {
+	return &r.out
+}
+
+func (r *RecordingRunner) ErrOutput() io.Writer {
+	return &r.out
+}
+
+// Commands returns the commands run so far, formatted like a shell would
+// show them.
+func (r *RecordingRunner) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, len(r.commands))
+	for i := range r.commands {
+		lines[i] = r.commands[i].String()
+	}
+	return lines
+}
+
+// Printed returns what stackctl and the commands printed.
+func (r *RecordingRunner) Printed() string {
+	return r.out.String()
+}
+
+// RunCmdCapture returns the combined stdout and stderr of a command.
+func RunCmdCapture(ctx context.Context, name string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := RunnerFrom(ctx).Run(ctx, &Cmd{Name: name, Args: args, Stdout: &out, Stderr: &out})
+	return out.String(), err
+}
+
+// RunCmdStream runs a command with its output going to the runner's.
+func RunCmdStream(ctx context.Context, name string, args ...string) error {
+	return RunnerFrom(ctx).Run(ctx, &Cmd{Name: name, Args: args})
+}
+
+// RunCmdOutput returns stdout alone, for output that is parsed; stderr is
+// folded into the error.
+func RunCmdOutput(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := RunnerFrom(ctx).Run(ctx, &Cmd{Name: name, Args: args, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), fmt.Errorf("%w: %s", err, msg)
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
 }
@@ -0,0 +1,109 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactWord(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"POSTGRES_PASSWORD=hunter2", "POSTGRES_PASSWORD=" + redacted},
+		{"api_token=abc", "api_token=" + redacted},
+		{"AWS_ACCESS_KEY_ID=AKIA", "AWS_ACCESS_KEY_ID=" + redacted},
+		{"STACKCTL_PARAM_PLUGINS=a,b", "STACKCTL_PARAM_PLUGINS=a,b"},
+		{"COMPOSE_PROJECT_NAME=dev", "COMPOSE_PROJECT_NAME=dev"},
+		{"postgres://app:s3cret@db:5432/app", "postgres://app:" + redacted + "@db:5432/app"},
+		{"https://user@example.com/repo.git", "https://user@example.com/repo.git"},
+		{"--remove-orphans", "--remove-orphans"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := redactWord(tt.word); got != tt.want {
+			t.Errorf("redactWord(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	c := &Cmd{
+		Name: "sh",
+		Args: []string{"hook.sh", "DB_PASSWORD=x", "two words"},
+		Env:  []string{"STACKCTL_ENV=dev", "STACKCTL_PARAM_ADMIN_PASSWORD=x"},
+	}
+	want := `STACKCTL_ENV=dev STACKCTL_PARAM_ADMIN_PASSWORD=` + redacted + ` sh hook.sh DB_PASSWORD=` + redacted + ` "two words"`
+	if got := redactCommand(c); got != want {
+		t.Errorf("redactCommand = %s\nwant %s", got, want)
+	}
+}
+
+func TestEdgeApplyCommands(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("STACKCTL_STACK_ROOT", root)
+	t.Setenv("STACKCTL_TEMPLATES", filepath.Join("..", "..", "templates"))
+	writeTestFile(t, filepath.Join(root, "stackctl.yml"), "edge:\n  enabled: true\n")
+	writeTestFile(t, filepath.Join(root, "dev", ".env"), "DOMAIN=dev.example.com\n")
+	writeTestFile(t, filepath.Join(root, "dev", "compose.yml"), "services: {}\n")
+
+	r := &RecordingRunner{Respond: func(c Cmd) (string, error) {
+		if strings.HasPrefix(c.String(), "docker network inspect") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}}
+	if err := Run(WithRunner(context.Background(), r), []string{"edge", "apply"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "edge")
+	want := []string{
+		"docker network inspect " + EdgeNetworkName,
+		"docker network create " + EdgeNetworkName,
+		"docker compose -f " + filepath.Join(dir, "compose.yml") + " -p " + edgeProjectName + " up -d --remove-orphans",
+	}
+	if got := r.Commands(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, line := range []string{"warning: dev still publishes 80/443", "applied edge proxy"} {
+		if !strings.Contains(r.Printed(), line) {
+			t.Errorf("printed %q, want a line with %q", r.Printed(), line)
+		}
+	}
+	route, err := os.ReadFile(filepath.Join(dir, "conf.d", "env-dev.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(route), "dev.example.com") {
+		t.Errorf("env-dev.conf does not route dev.example.com:\n%s", route)
+	}
+}
+
+func TestEdgeApplyFailure(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("STACKCTL_STACK_ROOT", root)
+	t.Setenv("STACKCTL_TEMPLATES", filepath.Join("..", "..", "templates"))
+	writeTestFile(t, filepath.Join(root, "stackctl.yml"), "edge:\n  enabled: true\n")
+
+	r := &RecordingRunner{Respond: func(c Cmd) (string, error) {
+		if strings.HasPrefix(c.String(), "docker network create") {
+			return "permission denied\n", errors.New("exit status 1")
+		}
+		if strings.HasPrefix(c.String(), "docker network inspect") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}}
+	err := Run(WithRunner(context.Background(), r), []string{"edge", "apply"})
+	if err == nil || !strings.Contains(err.Error(), "create network "+EdgeNetworkName+": permission denied") {
+		t.Fatalf("err = %v, want the output of the failed network create", err)
+	}
+	if n := len(r.Commands()); n != 2 {
+		t.Errorf("ran %d commands, want nothing after the failed network create:\n%s", n, strings.Join(r.Commands(), "\n"))
+	}
+}
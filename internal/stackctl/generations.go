@@ -0,0 +1,397 @@
+package stackctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	generationsDirName = ".generations"
+	keepGenerations    = 10
+)
+
+// generatedFiles are the files of the environment directory apply renders.
+// The rollback after a failed apply restores only these and generationDirs.
+var generatedFiles = []string{"compose.yml"}
+
+// inputFiles are what apply renders from. A generation snapshots them too, so
+// that stackctl rollback can put them back and a later apply renders the
+// same thing again.
+var inputFiles = []string{
+	"compose.override.yml",
+	".env",
+	"enabled.yml",
+	"apps.yml",
+	"images.lock",
+}
+
+// generationDirs are rendered by apply and snapshotted with all their files.
+var generationDirs = []string{
+	filepath.Join("nginx", "conf.d"),
+	"systemd",
+}
+
+// Generation is a snapshot of an environment's configuration taken after a
+// successful apply.
+type Generation struct {
+	Number  int       `yaml:"-"`
+	Created time.Time `yaml:"created"`
+	Modules []string  `yaml:"modules"`
+	// Hash is the applyHash of the snapshotted files.
+	Hash string `yaml:"hash"`
+	// Colors are the active colors of the blue/green services.
+	Colors map[string]string `yaml:"colors,omitempty"`
+}
+
+func generationsDir(cfg EnvConfig) string {
+	return filepath.Join(cfg.EnvDir, generationsDirName)
+}
+
+// ListGenerations returns the environment's generations, oldest first.
+func ListGenerations(cfg EnvConfig) ([]Generation, error) {
+	entries, err := os.ReadDir(generationsDir(cfg))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var gens []Generation
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(generationsDir(cfg), e.Name(), "generation.yml"))
+		if err != nil {
+			continue
+		}
+		var g Generation
+		if err := yaml.Unmarshal(b, &g); err != nil {
+			return nil, fmt.Errorf("parse generation %d: %w", n, err)
+		}
+		g.Number = n
+		gens = append(gens, g)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].Number < gens[j].Number })
+	return gens, nil
+}
+
+// saveGeneration snapshots the environment as the next generation and prunes
+// all but the newest keepGenerations.
+func saveGeneration(cfg EnvConfig, modules []string, hash string) (Generation, error) {
+	gens, err := ListGenerations(cfg)
+	if err != nil {
+		return Generation{}, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return Generation{}, err
+	}
+	g := Generation{Number: 1, Created: time.Now().UTC().Truncate(time.Second), Modules: modules, Hash: hash, Colors: st.Colors}
+	if len(gens) > 0 {
+		g.Number = gens[len(gens)-1].Number + 1
+	}
+
+	dir := filepath.Join(generationsDir(cfg), strconv.Itoa(g.Number))
+	if err := copyTree(cfg.EnvDir, dir, slices.Concat(generatedFiles, inputFiles)); err != nil {
+		os.RemoveAll(dir)
+		return Generation{}, fmt.Errorf("save generation %d: %w", g.Number, err)
+	}
+	meta, err := yaml.Marshal(g)
+	if err != nil {
+		return Generation{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "generation.yml"), meta, 0o640); err != nil {
+		return Generation{}, err
+	}
+
+	gens = append(gens, g)
+	for len(gens) > keepGenerations {
+		if err := os.RemoveAll(filepath.Join(generationsDir(cfg), strconv.Itoa(gens[0].Number))); err != nil {
+			return Generation{}, err
+		}
+		gens = gens[1:]
+	}
+	if err := pruneReplacedInputs(cfg); err != nil {
+		return Generation{}, err
+	}
+	return g, nil
+}
+
+// copyTree copies files and the generationDirs from src to dst, removing
+// those from dst that src does not have.
+func copyTree(src, dst string, files []string) error {
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(src, name)); errors.Is(err, fs.ErrNotExist) {
+			if err := os.Remove(filepath.Join(dst, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := copyIfExists(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	for _, d := range generationDirs {
+		if err := ensureDir(filepath.Join(dst, d), 0o750); err != nil {
+			return err
+		}
+		existing, err := os.ReadDir(filepath.Join(dst, d))
+		if err != nil {
+			return err
+		}
+		for _, e := range existing {
+			if _, err := os.Stat(filepath.Join(src, d, e.Name())); errors.Is(err, fs.ErrNotExist) {
+				if err := os.Remove(filepath.Join(dst, d, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		entries, err := os.ReadDir(filepath.Join(src, d))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if err := copyIfExists(filepath.Join(src, d, e.Name()), filepath.Join(dst, d, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyIfExists copies a file keeping its mode; .env holds secrets.
+func copyIfExists(src, dst string) error {
+	info, err := os.Stat(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, info.Mode().Perm())
+}
+
+// restoreGeneration copies generation n back into the environment directory:
+// the generated files, and with inputs also the files they were rendered
+// from. The inputs it replaces are saved first.
+func restoreGeneration(ctx context.Context, cfg EnvConfig, n int, inputs bool) (Generation, error) {
+	gens, err := ListGenerations(cfg)
+	if err != nil {
+		return Generation{}, err
+	}
+	for _, g := range gens {
+		if g.Number != n {
+			continue
+		}
+		dir := filepath.Join(generationsDir(cfg), strconv.Itoa(n))
+		files := generatedFiles
+		if inputs {
+			if err := saveReplacedInputs(ctx, cfg, dir); err != nil {
+				return Generation{}, err
+			}
+			files = slices.Concat(generatedFiles, inputFiles)
+		}
+		if err := copyTree(dir, cfg.EnvDir, files); err != nil {
+			return Generation{}, fmt.Errorf("restore generation %d: %w", n, err)
+		}
+		return g, nil
+	}
+	return Generation{}, fmt.Errorf("generation %d does not exist (see stackctl rollback --env %s --list)", n, cfg.EnvName)
+}
+
+// replacedPrefix starts the names of the directories saveReplacedInputs
+// writes; the rest is the time, so they sort oldest first.
+const replacedPrefix = "replaced-"
+
+// saveReplacedInputs copies the input files that differ from those of the
+// generation in dir to .generations/replaced-<time>, which git tracking
+// ignores, and says where they are. Only the owner can read them as .env
+// holds secrets.
+func saveReplacedInputs(ctx context.Context, cfg EnvConfig, dir string) error {
+	saveDir := filepath.Join(generationsDir(cfg), replacedPrefix+time.Now().Format("20060102-150405"))
+	var saved []string
+	for _, name := range inputFiles {
+		current, err := os.ReadFile(filepath.Join(cfg.EnvDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if old, err := os.ReadFile(filepath.Join(dir, name)); err == nil && bytes.Equal(old, current) {
+			continue
+		}
+		if err := ensureDir(saveDir, 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(saveDir, name), current, 0o600); err != nil {
+			return fmt.Errorf("save the current %s: %w", name, err)
+		}
+		saved = append(saved, name)
+	}
+	if len(saved) == 0 {
+		return nil
+	}
+	fmt.Fprintf(Out(ctx), "saved the replaced %s in %s\n", strings.Join(saved, ", "), saveDir)
+	return pruneReplacedInputs(cfg)
+}
+
+// pruneReplacedInputs removes all but the newest keepGenerations directories
+// of replaced inputs.
+func pruneReplacedInputs(cfg EnvConfig) error {
+	entries, err := os.ReadDir(generationsDir(cfg))
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), replacedPrefix) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	for len(dirs) > keepGenerations {
+		if err := os.RemoveAll(filepath.Join(generationsDir(cfg), dirs[0])); err != nil {
+			return err
+		}
+		dirs = dirs[1:]
+	}
+	return nil
+}
+
+// rollbackTo restores generation n, with its inputs or without, and brings
+// the services back to it. It runs no hooks.
+func rollbackTo(ctx context.Context, cfg EnvConfig, n int, inputs bool) error {
+	g, err := restoreGeneration(ctx, cfg, n, inputs)
+	if err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		units, _ := filepath.Glob(filepath.Join(cfg.EnvDir, "systemd", "*"))
+		if err := installSystemdUnits(ctx, cfg, units); err != nil {
+			return err
+		}
+	}
+
+	composeArgs := ComposeBaseArgs(cfg)
+	for _, module := range g.Modules {
+		composeArgs = append(composeArgs, "--profile", module)
+	}
+	composeArgs = append(composeArgs, "up", "-d", "--remove-orphans")
+	if err := RunCmdStream(ctx, "docker", composeArgs...); err != nil {
+		return fmt.Errorf("docker compose up for generation %d: %w", n, err)
+	}
+
+	st, err := LoadState(cfg)
+	if err != nil {
+		return err
+	}
+	switched := !maps.Equal(st.Colors, g.Colors)
+	st.Generation = n
+	st.AppliedHash = g.Hash
+	st.Colors = g.Colors
+	if err := WriteState(cfg, st); err != nil {
+		return err
+	}
+	if switched {
+		return restoreColors(ctx, cfg, g)
+	}
+	return nil
+}
+
+// restoreColors points nginx back at the colors of g and stops the others.
+func restoreColors(ctx context.Context, cfg EnvConfig, g Generation) error {
+	if err := reloadNginx(ctx, cfg); err != nil {
+		fmt.Fprintf(Out(ctx), "warning: %v\n", err)
+	}
+	services, err := blueGreenServices(g.Modules)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	args := append(colorArgs(cfg, g.Modules), "stop")
+	for _, svc := range services {
+		args = append(args, colorService(svc, otherColor(g.Colors[svc])))
+	}
+	return RunCmdStream(ctx, "docker", args...)
+}
+
+// Rollback restores generation to, or the generation before the current one
+// when to is 0.
+func Rollback(ctx context.Context, cfg EnvConfig, to int) error {
+	gens, err := ListGenerations(cfg)
+	if err != nil {
+		return err
+	}
+	if to == 0 {
+		st, err := LoadState(cfg)
+		if err != nil {
+			return err
+		}
+		for _, g := range gens {
+			if g.Number < st.Generation {
+				to = g.Number
+			}
+		}
+		if to == 0 {
+			return fmt.Errorf("no generation before %d to roll back to", st.Generation)
+		}
+	}
+	if err := rollbackTo(ctx, cfg, to, true); err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "rolled back %s to generation %d\n", cfg.EnvName, to)
+	return nil
+}
+
+// GenerationLines formats the generations for display, marking the current
+// one.
+func GenerationLines(cfg EnvConfig) ([]string, error) {
+	gens, err := ListGenerations(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for i := len(gens) - 1; i >= 0; i-- {
+		g := gens[i]
+		mark := " "
+		if g.Number == st.Generation {
+			mark = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s %3d  %s  %s", mark, g.Number, g.Created.Local().Format("2006-01-02 15:04:05"), strings.Join(g.Modules, ", ")))
+	}
+	return lines, nil
+}
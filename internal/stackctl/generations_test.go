@@ -0,0 +1,72 @@
+package stackctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveReplacedInputs(t *testing.T) {
+	cfg := newTestEnv(t, map[string]string{
+		".env":        "DB_PASSWORD=new\n",
+		"enabled.yml": "version: 2\nmodules: []\n",
+	})
+	gen := filepath.Join(generationsDir(cfg), "1")
+	writeTestFile(t, filepath.Join(gen, ".env"), "DB_PASSWORD=old\n")
+	writeTestFile(t, filepath.Join(gen, "enabled.yml"), "version: 2\nmodules: []\n")
+
+	r := &RecordingRunner{}
+	if err := saveReplacedInputs(WithRunner(context.Background(), r), cfg, gen); err != nil {
+		t.Fatal(err)
+	}
+	dirs, _ := filepath.Glob(filepath.Join(generationsDir(cfg), replacedPrefix+"*"))
+	if len(dirs) != 1 {
+		t.Fatalf("replaced dirs = %v, want one", dirs)
+	}
+	if !strings.Contains(r.Printed(), "saved the replaced .env in "+dirs[0]) {
+		t.Errorf("printed %q", r.Printed())
+	}
+	if _, err := os.Stat(filepath.Join(dirs[0], "enabled.yml")); err == nil {
+		t.Error("saved enabled.yml, which the generation has unchanged")
+	}
+	for path, want := range map[string]os.FileMode{
+		dirs[0]:                        0o700,
+		filepath.Join(dirs[0], ".env"): 0o600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s mode = %v, want %v", path, got, want)
+		}
+	}
+	if got := readTestFile(t, filepath.Join(dirs[0], ".env")); got != "DB_PASSWORD=new\n" {
+		t.Errorf("saved .env = %q", got)
+	}
+}
+
+func TestPruneReplacedInputs(t *testing.T) {
+	cfg := newTestEnv(t, nil)
+	for i := 1; i <= keepGenerations+2; i++ {
+		writeTestFile(t, filepath.Join(generationsDir(cfg), fmt.Sprintf("%s20260101-0000%02d", replacedPrefix, i), ".env"), "")
+	}
+	writeTestFile(t, filepath.Join(generationsDir(cfg), "1", "generation.yml"), "")
+
+	if err := pruneReplacedInputs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	dirs, _ := filepath.Glob(filepath.Join(generationsDir(cfg), replacedPrefix+"*"))
+	if len(dirs) != keepGenerations {
+		t.Fatalf("kept %d replaced dirs, want %d", len(dirs), keepGenerations)
+	}
+	if want := replacedPrefix + "20260101-000003"; filepath.Base(dirs[0]) != want {
+		t.Errorf("oldest kept = %s, want %s", filepath.Base(dirs[0]), want)
+	}
+	if _, err := os.Stat(filepath.Join(generationsDir(cfg), "1")); err != nil {
+		t.Errorf("pruned a generation: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package stackctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultWaitTimeout = 5 * time.Minute
+	healthPollInterval = 2 * time.Second
+)
+
+// ServiceHealth is the state of a service's container as docker compose ps
+// reports it. Health is empty for services without a healthcheck.
+type ServiceHealth struct {
+	Service  string
+	State    string
+	Health   string
+	ExitCode int
+}
+
+// Ready reports whether the service is running and, if it has a
+// healthcheck, healthy.
+func (s ServiceHealth) Ready() bool {
+	return s.State == "running" && (s.Health == "" || s.Health == "healthy")
+}
+
+// Status is a one-word summary: healthy, running, starting, unhealthy,
+// restarting, exited (n), missing and so on.
+func (s ServiceHealth) Status() string {
+	switch {
+	case s.State == "":
+		return "missing"
+	case s.State == "exited":
+		return fmt.Sprintf("exited (%d)", s.ExitCode)
+	case s.State != "running":
+		return s.State
+	case s.Health != "":
+		return s.Health
+	}
+	return "running"
+}
+
+// ExpectedServices lists the services docker compose up starts for modules:
+// those without a profile and those in an enabled module's profile.
+func ExpectedServices(cfg EnvConfig, modules []string) ([]string, error) {
+	profiles, err := composeProfiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	for _, name := range sortedKeys(profiles) {
+		active := len(profiles[name]) == 0
+		for _, p := range profiles[name] {
+			if contains(modules, p) {
+				active = true
+			}
+		}
+		if active {
+			services = append(services, name)
+		}
+	}
+	return services, nil
+}
+
+// ServiceStates returns the state of each of services; services without a
+// container come back with an empty State.
+func ServiceStates(ctx context.Context, cfg EnvConfig, modules, services []string) ([]ServiceHealth, error) {
+	args := ComposeBaseArgs(cfg)
+	for _, module := range modules {
+		args = append(args, "--profile", module)
+	}
+	args = append(args, "ps", "-a", "--format", "json")
+	out, err := RunCmdOutput(ctx, "docker", args...)
+	if err != nil {
+		return nil, fmt.Errorf("docker compose ps: %w", err)
+	}
+	containers, err := parseComposePS(out)
+	if err != nil {
+		return nil, err
+	}
+
+	byService := map[string]ServiceHealth{}
+	for _, c := range containers {
+		byService[c.Service] = c
+	}
+	states := make([]ServiceHealth, 0, len(services))
+	for _, name := range services {
+		st := byService[name]
+		st.Service = name
+		states = append(states, st)
+	}
+	return states, nil
+}
+
+// parseComposePS reads docker compose ps --format json, which is a JSON array
+// on older Compose releases and one object per line on newer ones.
+func parseComposePS(out string) ([]ServiceHealth, error) {
+	type container struct {
+		Service  string `json:"Service"`
+		State    string `json:"State"`
+		Health   string `json:"Health"`
+		ExitCode int    `json:"ExitCode"`
+	}
+	var containers []container
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "[") {
+		if err := json.Unmarshal([]byte(out), &containers); err != nil {
+			return nil, fmt.Errorf("parse docker compose ps: %w", err)
+		}
+	} else {
+		for _, line := range strings.Split(out, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var c container
+			if err := json.Unmarshal([]byte(line), &c); err != nil {
+				return nil, fmt.Errorf("parse docker compose ps: %w", err)
+			}
+			containers = append(containers, c)
+		}
+	}
+	states := make([]ServiceHealth, 0, len(containers))
+	for _, c := range containers {
+		states = append(states, ServiceHealth{Service: c.Service, State: c.State, Health: c.Health, ExitCode: c.ExitCode})
+	}
+	return states, nil
+}
+
+// WaitHealthy polls services until all of them are ready or timeout passes.
+// progress, if set, gets every poll's states. The last states are returned
+// either way.
+func WaitHealthy(ctx context.Context, cfg EnvConfig, modules, services []string, timeout time.Duration, progress func([]ServiceHealth)) ([]ServiceHealth, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		states, err := ServiceStates(ctx, cfg, modules, services)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(states)
+		}
+		var pending []string
+		for _, st := range states {
+			if !st.Ready() {
+				pending = append(pending, st.Service)
+			}
+		}
+		if len(pending) == 0 {
+			return states, nil
+		}
+		if time.Now().After(deadline) {
+			return states, fmt.Errorf("%d of %d services not healthy after %s: %s", len(pending), len(states), timeout, strings.Join(pending, ", "))
+		}
+		if err := sleep(ctx, healthPollInterval); err != nil {
+			return states, err
+		}
+	}
+}
+
+// HealthTable formats states one service per line.
+func HealthTable(states []ServiceHealth) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-24s %-14s %s\n", "SERVICE", "STATUS", "RESULT")
+	for _, st := range states {
+		mark := "ok"
+		if !st.Ready() {
+			mark = "FAIL"
+		}
+		fmt.Fprintf(&b, "  %-24s %-14s %s\n", st.Service, st.Status(), mark)
+	}
+	return b.String()
+}
+
+// printHealthChanges returns a progress func that prints a line whenever a
+// service's status changes.
+func printHealthChanges(ctx context.Context) func([]ServiceHealth) {
+	last := map[string]string{}
+	return func(states []ServiceHealth) {
+		for _, st := range states {
+			if status := st.Status(); last[st.Service] != status {
+				last[st.Service] = status
+				fmt.Fprintf(Out(ctx), "  %s: %s\n", st.Service, status)
+			}
+		}
+	}
+}
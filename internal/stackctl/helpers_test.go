@@ -0,0 +1,59 @@
+package stackctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// newTestEnv points the stack, data and backup roots and the systemd unit
+// directory at a temporary directory, uses the repository's templates and
+// returns the config of environment dev with files, relative to its
+// directory, written.
+func newTestEnv(t *testing.T, files map[string]string) EnvConfig {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("STACKCTL_STACK_ROOT", filepath.Join(root, "stack"))
+	t.Setenv("STACKCTL_DATA_ROOT", filepath.Join(root, "data"))
+	t.Setenv("STACKCTL_BACKUP_ROOT", filepath.Join(root, "backups"))
+	t.Setenv("STACKCTL_TEMPLATES", filepath.Join("..", "..", "templates"))
+	t.Setenv("STACKCTL_MODULE_PATH", "")
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(root, "no-docker.sock"))
+	unitDir := systemdUnitDir
+	systemdUnitDir = filepath.Join(root, "units")
+	t.Cleanup(func() { systemdUnitDir = unitDir })
+	if err := os.MkdirAll(systemdUnitDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadEnvConfig("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.EnvDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		writeTestFile(t, filepath.Join(cfg.EnvDir, name), content)
+	}
+	return cfg
+}
@@ -0,0 +1,287 @@
+package stackctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HistoryEntry is one record of an environment's history.log, written for
+// every command that changed the environment.
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Command string    `json:"command"`
+	// Modules are the enabled modules and Hash the compose content hash
+	// after the command ran.
+	Modules []string    `json:"modules,omitempty"`
+	Hash    string      `json:"hash,omitempty"`
+	Env     []EnvChange `json:"env,omitempty"`
+	Result  string      `json:"result"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// EnvChange is a .env key a command changed. Secret values are redacted.
+type EnvChange struct {
+	Key string `json:"key"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+const redacted = "<redacted>"
+
+func (c EnvChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("%s set to %s", c.Key, c.New)
+	case c.New == "":
+		return fmt.Sprintf("%s removed (was %s)", c.Key, c.Old)
+	case c.Old == redacted:
+		return fmt.Sprintf("%s changed", c.Key)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+func historyPath(cfg EnvConfig) string {
+	return filepath.Join(cfg.EnvDir, "history.log")
+}
+
+// recordHistory appends e to the environment's history, filling in the time
+// and user.
+func recordHistory(cfg EnvConfig, e HistoryEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	if e.User == "" {
+		e.User = currentUser()
+	}
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(cfg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// currentUser prefers the user behind sudo.
+func currentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// EnvRun is a command changing an environment. It holds the environment's
+// lock until Finish records it in the history.
+type EnvRun struct {
+	cfg        EnvConfig
+	command    string
+	env        map[string]string
+	generation int
+	unlock     func()
+}
+
+// StartEnvRun takes the environment's lock for command, waiting up to wait,
+// and remembers the .env to report the keys command changes.
+func StartEnvRun(ctx context.Context, cfg EnvConfig, command string, wait time.Duration) (*EnvRun, error) {
+	unlock, err := LockEnv(ctx, cfg, command, wait)
+	if err != nil {
+		return nil, err
+	}
+	env, _ := dotEnvVars(cfg)
+	st, _ := LoadState(cfg)
+	return &EnvRun{cfg: cfg, command: command, env: env, generation: st.Generation, unlock: unlock}, nil
+}
+
+// Finish records the run with its outcome and releases the lock. It does so
+// for an interrupted run too, so ctx being cancelled does not stop it.
+func (r *EnvRun) Finish(ctx context.Context, runErr error) {
+	ctx = context.WithoutCancel(ctx)
+	defer r.unlock()
+	if !DirExists(r.cfg.EnvDir) {
+		return
+	}
+	e := HistoryEntry{Command: r.command, Result: "ok", Hash: composeContentHash(r.cfg)}
+	if conf, err := LoadEnabled(r.cfg); err == nil {
+		e.Modules = conf.Modules
+	}
+	// A run that put a new generation live reports the .env changes since
+	// the previous one, including edits made by hand before it.
+	before := r.env
+	if st, err := LoadState(r.cfg); err == nil && r.generation > 0 && st.Generation != r.generation {
+		if env, err := ReadDotEnv(filepath.Join(generationsDir(r.cfg), strconv.Itoa(r.generation), ".env")); err == nil {
+			before = env
+		}
+	}
+	if env, err := dotEnvVars(r.cfg); err == nil {
+		e.Env = envChanges(before, env)
+	}
+	if runErr != nil {
+		e.Result = "failed"
+		e.Error = runErr.Error()
+	}
+	if err := recordHistory(r.cfg, e); err != nil {
+		fmt.Fprintf(Out(ctx), "warning: record history: %v\n", err)
+	}
+	if EnvConfigTracked(r.cfg) {
+		subject := r.command
+		if runErr != nil {
+			subject += " (failed)"
+		}
+		var body []string
+		for _, c := range e.Env {
+			body = append(body, ".env: "+c.String())
+		}
+		if err := commitEnvConfig(ctx, r.cfg, subject, body); err != nil {
+			fmt.Fprintf(Out(ctx), "warning: commit config: %v\n", err)
+		}
+	}
+}
+
+// envChanges lists the keys that differ between two .env files, redacting
+// the values of secrets.
+func envChanges(before, after map[string]string) []EnvChange {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	var changes []EnvChange
+	for _, k := range sortedKeys(keys) {
+		old, oldOK := before[k]
+		cur, curOK := after[k]
+		if old == cur && oldOK == curOK {
+			continue
+		}
+		if IsSecretKey(k) {
+			if oldOK {
+				old = redacted
+			}
+			if curOK {
+				cur = redacted
+			}
+		}
+		changes = append(changes, EnvChange{Key: k, Old: old, New: cur})
+	}
+	return changes
+}
+
+// composeContentHash reads the content hash apply stamped into compose.yml.
+func composeContentHash(cfg EnvConfig) string {
+	b, err := os.ReadFile(filepath.Join(cfg.EnvDir, "compose.yml"))
+	if err != nil {
+		return ""
+	}
+	var doc struct {
+		Stackctl struct {
+			ContentHash string `yaml:"content_hash"`
+		} `yaml:"x-stackctl"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return ""
+	}
+	return doc.Stackctl.ContentHash
+}
+
+// HistoryFilter selects history records; zero fields match everything.
+type HistoryFilter struct {
+	User    string
+	Command string
+	Since   time.Time
+	Failed  bool
+	Limit   int
+}
+
+func (f HistoryFilter) match(e HistoryEntry) bool {
+	switch {
+	case f.User != "" && e.User != f.User:
+		return false
+	case f.Command != "" && !strings.Contains(e.Command, f.Command):
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case f.Failed && e.Result == "ok":
+		return false
+	}
+	return true
+}
+
+// ReadHistory returns the environment's records matching filter, oldest
+// first; with a Limit only the newest ones.
+func ReadHistory(cfg EnvConfig, filter HistoryFilter) ([]HistoryEntry, error) {
+	f, err := os.Open(historyPath(cfg))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", historyPath(cfg), n, err)
+		}
+		if filter.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+// HistoryLines formats entries for display, one record per line followed by
+// its .env changes.
+func HistoryLines(entries []HistoryEntry) []string {
+	var lines []string
+	for _, e := range entries {
+		result := e.Result
+		if e.Error != "" {
+			result += ": " + e.Error
+		}
+		lines = append(lines, fmt.Sprintf("%s  %-10s %-40s %s", e.Time.Local().Format("2006-01-02 15:04:05"), e.User, e.Command, result))
+		for _, c := range e.Env {
+			lines = append(lines, "    "+c.String())
+		}
+	}
+	return lines
+}
@@ -0,0 +1,168 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Hook phases a module can declare under hooks: in module.yml.
+const (
+	HookPreApply    = "pre-apply"
+	HookPostApply   = "post-apply"
+	HookPreDisable  = "pre-disable"
+	HookPostDisable = "post-disable"
+)
+
+var hookPhases = []string{HookPreApply, HookPostApply, HookPreDisable, HookPostDisable}
+
+// Hook is a one-off action run at a lifecycle phase. It is either a host
+// script, relative to the module directory, or a `docker compose run` of one
+// of the environment's services. Command arguments are rendered like module
+// templates.
+type Hook struct {
+	Name    string   `yaml:"name"`
+	Script  string   `yaml:"script"`
+	Service string   `yaml:"service"`
+	Command []string `yaml:"command"`
+}
+
+// label is the hook's name, or a description of what it runs.
+func (h Hook) label() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	if h.Script != "" {
+		return "script " + h.Script
+	}
+	return "compose run " + h.Service
+}
+
+func validateHooks(info ModuleInfo) error {
+	for phase, hooks := range info.Hooks {
+		if !contains(hookPhases, phase) {
+			return fmt.Errorf("module %s: unknown hook phase %q (use %s)", info.Name, phase, strings.Join(hookPhases, ", "))
+		}
+		for _, h := range hooks {
+			if (h.Script == "") == (h.Service == "") {
+				return fmt.Errorf("module %s: each %s hook needs exactly one of script or service", info.Name, phase)
+			}
+			if h.Script != "" && !isLocalPath(h.Script) {
+				return fmt.Errorf("module %s: hook script %s must be inside the module directory", info.Name, h.Script)
+			}
+		}
+	}
+	return nil
+}
+
+// RunModuleHooks runs the phase hooks of modules in dependency order, or in
+// reverse dependency order for the disable phases. values are the params set
+// in enabled.yml. The first failing hook stops the run.
+func RunModuleHooks(ctx context.Context, cfg EnvConfig, phase string, modules []string, values map[string]map[string]string) error {
+	catalog, err := loadCatalog(ctx)
+	if err != nil {
+		return err
+	}
+
+	var known []string
+	for _, name := range modules {
+		if _, ok := catalog[name]; ok {
+			known = append(known, name)
+		}
+	}
+	res, err := catalog.Resolve(known)
+	if err != nil {
+		return err
+	}
+	order := slices.DeleteFunc(res.Order, func(name string) bool { return !contains(known, name) })
+	if phase == HookPreDisable || phase == HookPostDisable {
+		slices.Reverse(order)
+	}
+
+	data := cfg.RenderData()
+	vhostsLoaded := false
+	for _, name := range order {
+		info := catalog[name]
+		hooks := info.Hooks[phase]
+		if len(hooks) == 0 {
+			continue
+		}
+		params, err := info.ResolveParams(values[name])
+		if err != nil {
+			return err
+		}
+		if !vhostsLoaded {
+			if data.Vhosts, err = envVhosts(ctx, cfg); err != nil {
+				return err
+			}
+			vhostsLoaded = true
+		}
+		data.Module = ModuleData{Name: name, Params: params}
+		for _, h := range hooks {
+			label := h.label()
+			fmt.Fprintf(Out(ctx), "==> %s %s: %s\n", name, phase, label)
+			if err := runHook(ctx, cfg, info, data, h); err != nil {
+				return fmt.Errorf("%s hook %q of module %s failed: %w", phase, label, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, cfg EnvConfig, info ModuleInfo, data RenderData, h Hook) error {
+	args := make([]string, 0, len(h.Command))
+	for _, arg := range h.Command {
+		rendered, err := renderString(arg, data)
+		if err != nil {
+			return fmt.Errorf("render command: %w", err)
+		}
+		args = append(args, rendered)
+	}
+
+	cmd := &Cmd{Dir: cfg.EnvDir, Env: hookEnv(cfg, info.Name, data.Module.Params)}
+	if h.Script != "" {
+		script := filepath.Join(info.Dir, h.Script)
+		if _, err := os.Stat(script); err != nil {
+			return err
+		}
+		cmd.Name, cmd.Args = "sh", append([]string{script}, args...)
+	} else {
+		composeArgs := ComposeBaseArgs(cfg)
+		composeArgs = append(composeArgs, "--profile", info.Name, "run", "--rm", "-T", h.Service)
+		cmd.Name, cmd.Args = "docker", append(composeArgs, args...)
+	}
+
+	err := RunnerFrom(ctx).Run(ctx, cmd)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("exit status %d", exitErr.ExitCode())
+	}
+	return err
+}
+
+// hookEnv describes the environment to hook scripts. COMPOSE_* lets a script
+// call plain `docker compose` against the environment's project.
+func hookEnv(cfg EnvConfig, module string, params map[string]any) []string {
+	env := []string{
+		"STACKCTL_ENV=" + cfg.EnvName,
+		"STACKCTL_MODULE=" + module,
+		"STACKCTL_ENV_DIR=" + cfg.EnvDir,
+		"STACKCTL_MODULE_DIR=" + filepath.Join(cfg.EnvDir, module),
+		"STACKCTL_DATA_DIR=" + filepath.Join(cfg.DataRoot, cfg.EnvName),
+		"STACKCTL_BACKUP_DIR=" + filepath.Join(cfg.BackupRoot, cfg.EnvName),
+		"STACKCTL_DOMAIN=" + cfg.Domain,
+		"STACKCTL_EMAIL=" + cfg.Email,
+		"COMPOSE_PROJECT_NAME=" + cfg.EnvName,
+		"COMPOSE_FILE=" + filepath.Join(cfg.EnvDir, "compose.yml") + string(os.PathListSeparator) + filepath.Join(cfg.EnvDir, "compose.override.yml"),
+		"COMPOSE_ENV_FILES=" + filepath.Join(cfg.EnvDir, ".env"),
+	}
+	for _, key := range sortedKeys(params) {
+		env = append(env, fmt.Sprintf("STACKCTL_PARAM_%s=%v", strings.ToUpper(key), params[key]))
+	}
+	return env
+}
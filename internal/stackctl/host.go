@@ -0,0 +1,55 @@
+package stackctl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HostConfig is the host-wide configuration shared by every environment.
+// It lives at <stack root>/stackctl.yml.
+type HostConfig struct {
+	Environments []string   `yaml:"environments"`
+	Edge         EdgeConfig `yaml:"edge,omitempty"`
+	// ModulePaths are extra directories holding module packs, searched after
+	// the built-in templates/modules. Relative paths are resolved against the
+	// stack root.
+	ModulePaths []string `yaml:"module_paths,omitempty"`
+}
+
+type EdgeConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+func hostConfigPath() string {
+	return filepath.Join(GetStackRoot(), "stackctl.yml")
+}
+
+func LoadHostConfig() (HostConfig, error) {
+	b, err := os.ReadFile(hostConfigPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return HostConfig{}, nil
+	}
+	if err != nil {
+		return HostConfig{}, err
+	}
+	var conf HostConfig
+	if err := yaml.Unmarshal(b, &conf); err != nil {
+		return HostConfig{}, err
+	}
+	return conf, nil
+}
+
+func WriteHostConfig(conf HostConfig) error {
+	out, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(GetStackRoot(), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(hostConfigPath(), out, 0o640)
+}
@@ -0,0 +1,276 @@
+package stackctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ImageLock is an environment's images.lock: every image reference of the
+// generated compose file, as written after .env interpolation, pinned to a
+// registry digest.
+type ImageLock struct {
+	Images map[string]string `yaml:"images"`
+}
+
+const imageLockHeader = "# Written by stackctl lock. apply runs these digests instead of the tags;\n# delete this file to go back to floating tags.\n"
+
+func imageLockPath(cfg EnvConfig) string {
+	return filepath.Join(cfg.EnvDir, "images.lock")
+}
+
+// LoadImageLock reads images.lock; without one nothing is pinned.
+func LoadImageLock(cfg EnvConfig) (ImageLock, error) {
+	b, err := os.ReadFile(imageLockPath(cfg))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ImageLock{}, nil
+	}
+	if err != nil {
+		return ImageLock{}, err
+	}
+	var lock ImageLock
+	if err := yaml.Unmarshal(b, &lock); err != nil {
+		return ImageLock{}, fmt.Errorf("parse images.lock: %w", err)
+	}
+	return lock, nil
+}
+
+func WriteImageLock(cfg EnvConfig, lock ImageLock) error {
+	out, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(imageLockPath(cfg), append([]byte(imageLockHeader), out...), 0o640)
+}
+
+// dotEnvVars reads the environment's .env for interpolation; a missing file
+// means no variables.
+func dotEnvVars(cfg EnvConfig) (map[string]string, error) {
+	vars, err := ReadDotEnv(filepath.Join(cfg.EnvDir, ".env"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	return vars, err
+}
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default} and ${VAR-default} the
+// way Compose does for the values stackctl needs to see resolved.
+func interpolate(s string, vars map[string]string) string {
+	return os.Expand(s, func(expr string) string {
+		if expr == "$" {
+			return "$"
+		}
+		if name, def, ok := strings.Cut(expr, ":-"); ok {
+			if v := vars[name]; v != "" {
+				return v
+			}
+			return def
+		}
+		if name, def, ok := strings.Cut(expr, "-"); ok {
+			if v, set := vars[name]; set {
+				return v
+			}
+			return def
+		}
+		return vars[expr]
+	})
+}
+
+// serviceImages returns the interpolated image of each service in the
+// compose document, keyed by service.
+func serviceImages(root *yaml.Node, vars map[string]string) map[string]string {
+	images := map[string]string{}
+	services := mappingValue(root, "services")
+	if services == nil {
+		return images
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		if image := mappingValue(services.Content[i+1], "image"); image != nil && image.Value != "" {
+			images[services.Content[i].Value] = interpolate(image.Value, vars)
+		}
+	}
+	return images
+}
+
+// pinImages replaces the images of root that lock knows with their digest
+// reference and returns how many services it pinned.
+func pinImages(cfg EnvConfig, root *yaml.Node, lock ImageLock) (int, error) {
+	if len(lock.Images) == 0 {
+		return 0, nil
+	}
+	vars, err := dotEnvVars(cfg)
+	if err != nil {
+		return 0, err
+	}
+	services := mappingValue(root, "services")
+	pinned := 0
+	for service, image := range serviceImages(root, vars) {
+		digest, ok := lock.Images[image]
+		if !ok {
+			continue
+		}
+		node := mappingValue(mappingValue(services, service), "image")
+		node.Value = digest
+		node.Style = 0
+		node.Tag = "!!str"
+		pinned++
+	}
+	return pinned, nil
+}
+
+// imageRepository strips the tag and digest from an image reference.
+func imageRepository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// localDigest returns the repo@sha256 reference docker has recorded for a
+// locally present image.
+func localDigest(ctx context.Context, image string) (string, error) {
+	out, err := RunCmdOutput(ctx, "docker", "image", "inspect", "--format", `{{join .RepoDigests "\n"}}`, image)
+	if err != nil {
+		return "", fmt.Errorf("%s is not present locally (docker compose pull first): %w", image, err)
+	}
+	digests := strings.Fields(out)
+	if len(digests) == 0 {
+		return "", fmt.Errorf("%s has no registry digest (built locally?)", image)
+	}
+	repo := imageRepository(image)
+	for _, d := range digests {
+		if imageRepository(d) == repo {
+			return d, nil
+		}
+	}
+	return digests[0], nil
+}
+
+// LockImages resolves every image of the environment's compose file to its
+// local digest and writes images.lock.
+func LockImages(ctx context.Context, cfg EnvConfig) error {
+	modules, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	ports, err := planPorts(cfg, modules)
+	if err != nil {
+		return err
+	}
+	merged, err := buildCompose(cfg, modules, ports)
+	if err != nil {
+		return err
+	}
+	vars, err := dotEnvVars(cfg)
+	if err != nil {
+		return err
+	}
+
+	lock := ImageLock{Images: map[string]string{}}
+	var failed []string
+	images := serviceImages(merged, vars)
+	for _, service := range sortedKeys(images) {
+		image := images[service]
+		if _, done := lock.Images[image]; done {
+			continue
+		}
+		if strings.Contains(image, "@sha256:") {
+			lock.Images[image] = image
+			continue
+		}
+		digest, err := localDigest(ctx, image)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", service, err))
+			continue
+		}
+		lock.Images[image] = digest
+		fmt.Fprintf(Out(ctx), "  %s -> %s\n", image, digest)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot lock %d image(s):\n  %s", len(failed), strings.Join(failed, "\n  "))
+	}
+	if err := WriteImageLock(cfg, lock); err != nil {
+		return err
+	}
+	fmt.Fprintf(Out(ctx), "locked %d images in %s\n", len(lock.Images), imageLockPath(cfg))
+	fmt.Fprintf(Out(ctx), "run: stackctl apply --env %s\n", cfg.EnvName)
+	return nil
+}
+
+// CheckImageLock compares the image each of the project's containers runs
+// with the digest images.lock pins for it.
+func CheckImageLock(ctx context.Context, cfg EnvConfig) error {
+	lock, err := LoadImageLock(cfg)
+	if err != nil {
+		return err
+	}
+	if len(lock.Images) == 0 {
+		return fmt.Errorf("no images.lock for %s (run stackctl lock --env %s)", cfg.EnvName, cfg.EnvName)
+	}
+	pinned := map[string]bool{}
+	for _, digest := range lock.Images {
+		pinned[digest] = true
+	}
+
+	out, err := RunCmdOutput(ctx, "docker", "ps", "-a",
+		"--filter", "label=com.docker.compose.project="+cfg.EnvName,
+		"--format", "{{.Label \"com.docker.compose.service\"}}\t{{.Image}}\t{{.ID}}")
+	if err != nil {
+		return fmt.Errorf("docker ps: %w", err)
+	}
+
+	drift := 0
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		service, ref, id := fields[0], fields[1], fields[2]
+		// Containers of a locked apply were created from the digest; older
+		// ones from the tag the lock was made from.
+		want, ok := lock.Images[ref]
+		if pinned[ref] {
+			want, ok = ref, true
+		}
+		if !ok {
+			fmt.Fprintf(Out(ctx), "  %-24s not locked (%s)\n", service, ref)
+			drift++
+			continue
+		}
+		running, err := RunCmdOutput(ctx, "docker", "inspect", "--format", "{{.Image}}", id)
+		if err != nil {
+			return fmt.Errorf("inspect %s container: %w", service, err)
+		}
+		locked, err := RunCmdOutput(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", want)
+		if err != nil {
+			fmt.Fprintf(Out(ctx), "  %-24s locked image %s is not present locally\n", service, want)
+			drift++
+			continue
+		}
+		if strings.TrimSpace(running) != strings.TrimSpace(locked) {
+			fmt.Fprintf(Out(ctx), "  %-24s drift: runs %s (%s), lock pins %s\n", service, shortID(running), ref, want)
+			drift++
+			continue
+		}
+		fmt.Fprintf(Out(ctx), "  %-24s ok\n", service)
+	}
+	if drift > 0 {
+		return fmt.Errorf("%d container(s) differ from images.lock", drift)
+	}
+	return nil
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(strings.TrimSpace(id), "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
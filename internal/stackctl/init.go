@@ -1,15 +1,22 @@
 package stackctl
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func RunInit(cfg EnvConfig) error {
+// RunInit creates the environment. preset picks the modules of a new
+// environment's enabled.yml; empty means the default preset.
+func RunInit(ctx context.Context, cfg EnvConfig, preset string) error {
 	if err := ensureDir(cfg.EnvDir, 0o750); err != nil {
 		return err
 	}
+	if err := RegisterEnvironment(cfg.EnvName); err != nil {
+		return err
+	}
 	if err := ensureDir(cfg.DataRoot, 0o750); err != nil {
 		return err
 	}
@@ -23,7 +30,7 @@ func RunInit(cfg EnvConfig) error {
 	if err := ensureEnvDirs(cfg); err != nil {
 		return err
 	}
-	if err := ensureDefaultEnabled(cfg); err != nil {
+	if err := ensureDefaultEnabled(ctx, cfg, preset); err != nil {
 		return err
 	}
 	if err := ensureDotEnv(cfg); err != nil {
@@ -32,8 +39,11 @@ func RunInit(cfg EnvConfig) error {
 	if err := ensureComposeOverride(cfg); err != nil {
 		return err
 	}
+	if err := ensureAppsFile(cfg); err != nil {
+		return err
+	}
 
-	modules, err := LoadEnabledModules(cfg)
+	modules, err := LoadEnabledModules(ctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -50,12 +60,12 @@ func RunInit(cfg EnvConfig) error {
 	if err := writeBackupScript(cfg); err != nil {
 		return err
 	}
-	if err := writeSystemdFiles(cfg); err != nil {
+	if err := writeSystemdFiles(ctx, cfg); err != nil {
 		return err
 	}
 
-	fmt.Printf("initialized %s at %s\n", cfg.EnvName, cfg.EnvDir)
-	fmt.Printf("next: stackctl apply --env %s\n", cfg.EnvName)
+	fmt.Fprintf(Out(ctx), "initialized %s at %s\n", cfg.EnvName, cfg.EnvDir)
+	fmt.Fprintf(Out(ctx), "next: stackctl apply --env %s\n", cfg.EnvName)
 	return nil
 }
 
@@ -84,13 +94,32 @@ func ensureEnvDirs(cfg EnvConfig) error {
 	return nil
 }
 
-func ensureDefaultEnabled(cfg EnvConfig) error {
+func ensureDefaultEnabled(ctx context.Context, cfg EnvConfig, preset string) error {
 	path := filepath.Join(cfg.EnvDir, "enabled.yml")
 	if _, err := os.Stat(path); err == nil {
+		if preset != "" {
+			fmt.Fprintf(Out(ctx), "enabled.yml already exists; preset %s not applied\n", preset)
+		}
 		return nil
 	}
-	def := EnabledConfig{Modules: []string{}}
-	return WriteEnabled(cfg, def)
+
+	presets, err := LoadPresets()
+	if err != nil {
+		return err
+	}
+	catalog, err := loadCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	modules, err := presets.Modules(catalog, preset)
+	if err != nil {
+		return err
+	}
+	if preset == "" {
+		preset = presets.Default
+	}
+	fmt.Fprintf(Out(ctx), "preset %s: %s\n", preset, strings.Join(modules, ", "))
+	return WriteEnabled(cfg, EnabledConfig{Modules: modules})
 }
 
 func ensureDotEnv(cfg EnvConfig) error {
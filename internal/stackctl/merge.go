@@ -0,0 +1,250 @@
+package stackctl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Compose-style merge tags. A value tagged !reset removes the key from the
+// merged file; !override replaces the merged value instead of merging into it.
+const (
+	tagReset    = "!reset"
+	tagOverride = "!override"
+)
+
+// parseYAMLMapping parses a compose document. An empty document yields a nil
+// node.
+func parseYAMLMapping(text, name string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse %s: top level must be a mapping", name)
+	}
+	return root, nil
+}
+
+// mergeMapping merges the mapping src into dst. Mappings merge key by key,
+// sequences gain the items they do not already contain, and anything else is
+// replaced. Keys keep their order, new keys are appended, and comments travel
+// with their nodes.
+func mergeMapping(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, val := src.Content[i], src.Content[i+1]
+		idx := mappingIndex(dst, key.Value)
+
+		switch val.Tag {
+		case tagReset:
+			if idx >= 0 {
+				dst.Content = append(dst.Content[:idx], dst.Content[idx+2:]...)
+			}
+			continue
+		case tagOverride:
+			val.Tag = ""
+			cleanMergeTags(val)
+			if idx >= 0 {
+				keepComments(dst.Content[idx], key)
+				dst.Content[idx+1] = val
+			} else {
+				dst.Content = append(dst.Content, key, val)
+			}
+			continue
+		}
+
+		if idx < 0 {
+			cleanMergeTags(val)
+			dst.Content = append(dst.Content, key, val)
+			continue
+		}
+
+		cur := dst.Content[idx+1]
+		if cur.Kind == yaml.AliasNode && val.Kind == cur.Alias.Kind && val.Kind != yaml.ScalarNode {
+			// Merging into an alias would change every user of the anchor.
+			cur = copyNode(cur.Alias)
+			cur.Anchor = ""
+			dst.Content[idx+1] = cur
+		}
+		switch {
+		case cur.Kind == yaml.MappingNode && val.Kind == yaml.MappingNode:
+			mergeMapping(cur, val)
+		case cur.Kind == yaml.SequenceNode && val.Kind == yaml.SequenceNode:
+			appendUnique(cur, val)
+		default:
+			cleanMergeTags(val)
+			keepComments(dst.Content[idx], key)
+			dst.Content[idx+1] = val
+		}
+	}
+}
+
+// keepComments moves comments written on an overlay's key onto the key
+// already in the merged file when the overlay replaces its value.
+func keepComments(dst, src *yaml.Node) {
+	if src.HeadComment != "" {
+		dst.HeadComment = src.HeadComment
+	}
+	if src.LineComment != "" {
+		dst.LineComment = src.LineComment
+	}
+}
+
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in the mapping m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if idx := mappingIndex(m, key); idx >= 0 {
+		return m.Content[idx+1]
+	}
+	return nil
+}
+
+// ensureMapping returns the mapping stored under key, adding an empty one
+// when key is missing or holds something else.
+func ensureMapping(m *yaml.Node, key string) *yaml.Node {
+	if v := mappingValue(m, key); v != nil && v.Kind == yaml.MappingNode {
+		return v
+	}
+	v := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if idx := mappingIndex(m, key); idx >= 0 {
+		m.Content[idx+1] = v
+	} else {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	}
+	return v
+}
+
+// setMappingValue encodes value and stores it under key, replacing an
+// existing entry in place.
+func setMappingValue(m *yaml.Node, key string, value any) error {
+	var n yaml.Node
+	if err := n.Encode(value); err != nil {
+		return err
+	}
+	if idx := mappingIndex(m, key); idx >= 0 {
+		m.Content[idx+1] = &n
+		return nil
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &n)
+	return nil
+}
+
+func appendUnique(dst, src *yaml.Node) {
+	for _, item := range src.Content {
+		cleanMergeTags(item)
+		dup := false
+		for _, existing := range dst.Content {
+			if sameValue(existing, item) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			dst.Content = append(dst.Content, item)
+		}
+	}
+}
+
+// sameValue compares two nodes by the value they decode to, so "80:80" and
+// '80:80' are the same port.
+func sameValue(a, b *yaml.Node) bool {
+	var va, vb any
+	if a.Decode(&va) != nil || b.Decode(&vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// cleanMergeTags drops !reset entries and !override tags from a value that
+// is added without merging, so they do not leak into the output.
+func cleanMergeTags(n *yaml.Node) {
+	if n.Tag == tagOverride || n.Tag == tagReset {
+		n.Tag = ""
+	}
+	if n.Kind == yaml.MappingNode {
+		content := n.Content[:0]
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i+1].Tag == tagReset {
+				continue
+			}
+			content = append(content, n.Content[i], n.Content[i+1])
+		}
+		n.Content = content
+	}
+	if n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode {
+		for _, c := range n.Content {
+			cleanMergeTags(c)
+		}
+	}
+}
+
+func copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// hoistExtensions moves the top-level x-* keys up behind name, so anchors a
+// module defines there come before the services that use them.
+func hoistExtensions(root *yaml.Node) {
+	var head, ext, rest []*yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		pair := root.Content[i : i+2]
+		switch key := root.Content[i].Value; {
+		case key == "name":
+			head = append(head, pair...)
+		case strings.HasPrefix(key, "x-"):
+			ext = append(ext, pair...)
+		default:
+			rest = append(rest, pair...)
+		}
+	}
+	root.Content = append(append(head, ext...), rest...)
+}
+
+// untagMergeKeys clears the resolved tag of << keys, which the encoder would
+// otherwise write out as "!!merge <<".
+func untagMergeKeys(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if k := n.Content[i]; k.Tag == "!!merge" {
+				k.Tag = ""
+			}
+		}
+	}
+	for _, c := range n.Content {
+		untagMergeKeys(c)
+	}
+}
+
+func encodeYAML(root *yaml.Node) ([]byte, error) {
+	untagMergeKeys(root)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
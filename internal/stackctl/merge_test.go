@@ -0,0 +1,283 @@
+package stackctl
+
+import "testing"
+
+// merge merges the overlays into base the way buildCompose does and returns
+// the encoded result, which is what the content hash is taken over.
+func merge(t *testing.T, base string, overlays ...string) string {
+	t.Helper()
+	merged, err := parseYAMLMapping(base, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range overlays {
+		overlay, err := parseYAMLMapping(text, "overlay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mergeMapping(merged, overlay)
+	}
+	hoistExtensions(merged)
+	out, err := encodeYAML(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestMergeMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		overlays []string
+		want     string
+	}{
+		{
+			name: "scalars are replaced and mappings merged key by key",
+			base: `
+services:
+  web:
+    image: web:1
+    environment:
+      A: "1"
+      B: "2"
+`,
+			overlays: []string{`
+services:
+  web:
+    image: web:2
+    environment:
+      B: "3"
+      C: "4"
+  db:
+    image: postgres
+`},
+			want: `services:
+  web:
+    image: web:2
+    environment:
+      A: "1"
+      B: "3"
+      C: "4"
+  db:
+    image: postgres
+`,
+		},
+		{
+			name: "lists gain only new items",
+			base: `
+services:
+  web:
+    ports:
+      - "80:80"
+    command: [serve, --port, 80]
+`,
+			overlays: []string{`
+services:
+  web:
+    ports:
+      - '80:80'
+      - 443:443
+    command: [serve, --debug]
+`},
+			want: `services:
+  web:
+    ports:
+      - "80:80"
+      - 443:443
+    command: [serve, --port, 80, --debug]
+`,
+		},
+		{
+			name: "list items compare by value",
+			base: `
+services:
+  web:
+    volumes:
+      - {type: bind, source: ./a, target: /a}
+`,
+			overlays: []string{`
+services:
+  web:
+    volumes:
+      - type: bind
+        target: /a
+        source: ./a
+      - {type: bind, source: ./b, target: /b}
+`},
+			want: `services:
+  web:
+    volumes:
+      - {type: bind, source: ./a, target: /a}
+      - {type: bind, source: ./b, target: /b}
+`,
+		},
+		{
+			name: "reset removes the key",
+			base: `
+services:
+  web:
+    image: web:1
+    ports:
+      - "80:80"
+    healthcheck:
+      test: [CMD, true]
+`,
+			overlays: []string{`
+services:
+  web:
+    ports: !reset []
+    healthcheck: !reset null
+    missing: !reset null
+`},
+			want: `services:
+  web:
+    image: web:1
+`,
+		},
+		{
+			name: "override replaces the value",
+			base: `
+services:
+  web:
+    ports:
+      - "80:80"
+    environment:
+      A: "1"
+`,
+			overlays: []string{`
+services:
+  web:
+    ports: !override
+      - "8080:80"
+    environment: !override
+      B: "2"
+    labels: !override
+      x: "y"
+`},
+			want: `services:
+  web:
+    ports:
+      - "8080:80"
+    environment:
+      B: "2"
+    labels:
+      x: "y"
+`,
+		},
+		{
+			name: "tags in added values are dropped",
+			base: `
+services:
+  db:
+    image: postgres
+`,
+			overlays: []string{`
+services:
+  web:
+    image: web:1
+    ports: !reset []
+    environment: !override
+      A: "1"
+      B: !reset null
+    volumes:
+      - !override ./data:/data
+`},
+			want: `services:
+  db:
+    image: postgres
+  web:
+    image: web:1
+    environment:
+      A: "1"
+    volumes:
+      - ./data:/data
+`,
+		},
+		{
+			name: "merging into an alias leaves the anchor alone",
+			base: `
+x-env: &env
+  A: "1"
+services:
+  web:
+    environment: *env
+  worker:
+    environment: *env
+`,
+			overlays: []string{`
+services:
+  web:
+    environment:
+      B: "2"
+`},
+			want: `x-env: &env
+  A: "1"
+services:
+  web:
+    environment:
+      A: "1"
+      B: "2"
+  worker:
+    environment: *env
+`,
+		},
+		{
+			name: "extensions move behind the name",
+			base: `
+services:
+  web:
+    image: web:1
+name: dev
+`,
+			overlays: []string{`
+x-logging: &logging
+  driver: local
+services:
+  web:
+    logging: *logging
+`, `
+volumes:
+  data: {}
+x-other: 1
+`},
+			want: `name: dev
+x-logging: &logging
+  driver: local
+x-other: 1
+services:
+  web:
+    image: web:1
+    logging: *logging
+volumes:
+  data: {}
+`,
+		},
+		{
+			name: "comments travel with replaced values",
+			base: `
+services:
+  web:
+    image: web:1
+`,
+			overlays: []string{`
+services:
+  web:
+    # pinned for the migration
+    image: web:2 # until 3 is out
+`},
+			want: `services:
+  web:
+    # pinned for the migration
+    image: web:2 # until 3 is out
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := merge(t, tt.base, tt.overlays...); got != tt.want {
+				t.Errorf("merged:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
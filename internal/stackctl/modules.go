@@ -1,99 +1,147 @@
 package stackctl
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-type ModuleInfo struct {
-	Name        string
-	Description string
-	Ports       []string
-	Category    string
+// EnabledConfig is an environment's enabled.yml. A module is listed either
+// by name or, when it has params set, as a single-key map:
+//
+//	version: 2
+//	modules:
+//	  - grafana
+//	  - prometheus:
+//	      retention_days: "30"
+type EnabledConfig struct {
+	Modules []string
+	// Params holds the params set per module; unset params use the
+	// module's defaults.
+	Params map[string]map[string]string
 }
 
-var ModuleCatalog = map[string]ModuleInfo{
-	"socket-proxy": {
-		Name:        "socket-proxy",
-		Description: "Docker socket proxy for safer container API access",
-		Ports:       []string{"127.0.0.1:2375"},
-		Category:    "Infrastructure",
-	},
-	"dozzle": {
-		Name:        "dozzle",
-		Description: "Container log viewer",
-		Ports:       []string{"127.0.0.1:9999"},
-		Category:    "Observability",
-	},
-	"node-exporter": {
-		Name:        "node-exporter",
-		Description: "Host metrics exporter",
-		Ports:       []string{"127.0.0.1:9100"},
-		Category:    "Observability",
-	},
-	"prometheus": {
-		Name:        "prometheus",
-		Description: "Metrics scraping and storage",
-		Ports:       []string{"127.0.0.1:9090"},
-		Category:    "Observability",
-	},
-	"alertmanager": {
-		Name:        "alertmanager",
-		Description: "Alert routing",
-		Ports:       []string{"127.0.0.1:9093"},
-		Category:    "Observability",
-	},
-	"grafana": {
-		Name:        "grafana",
-		Description: "Dashboards",
-		Ports:       []string{"127.0.0.1:3000"},
-		Category:    "Observability",
-	},
-	"loki": {
-		Name:        "loki",
-		Description: "Log aggregation",
-		Ports:       []string{"127.0.0.1:3100"},
-		Category:    "Observability",
-	},
-	"jaeger": {
-		Name:        "jaeger",
-		Description: "Distributed tracing",
-		Ports:       []string{"127.0.0.1:16686", "127.0.0.1:4317", "127.0.0.1:4318"},
-		Category:    "Observability",
-	},
-	"kuma": {
-		Name:        "kuma",
-		Description: "Uptime Kuma monitoring",
-		Ports:       []string{"127.0.0.1:3001"},
-		Category:    "Infrastructure",
-	},
-	"certbot": {
-		Name:        "certbot",
-		Description: "Optional certificate management helper",
-		Ports:       []string{},
-		Category:    "Infrastructure",
-	},
-	"backup": {
-		Name:        "backup",
-		Description: "Backup sidecar tools and hooks",
-		Ports:       []string{},
-		Category:    "Utilities",
-	},
+// enabledVersion is the current enabled.yml format. Version 1 files, which
+// have no version key, predate the core services becoming modules; those
+// services always ran, so they are enabled implicitly when such a file is
+// read.
+const enabledVersion = 2
+
+var legacyCoreModules = []string{"frontend", "backend", "keycloak", "postgres", "mariadb"}
+
+type enabledFile struct {
+	Version int         `yaml:"version"`
+	Modules []yaml.Node `yaml:"modules"`
 }
 
-var ModuleDependencies = map[string][]string{
-	"dozzle": {"socket-proxy"},
+func (c *EnabledConfig) UnmarshalYAML(value *yaml.Node) error {
+	var file enabledFile
+	if err := value.Decode(&file); err != nil {
+		return err
+	}
+	c.Modules = nil
+	c.Params = nil
+	for _, item := range file.Modules {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			c.Modules = append(c.Modules, item.Value)
+		case yaml.MappingNode:
+			var entry map[string]map[string]string
+			if err := item.Decode(&entry); err != nil {
+				return fmt.Errorf("line %d: module params must be key: value pairs: %w", item.Line, err)
+			}
+			if len(entry) != 1 {
+				return fmt.Errorf("line %d: expected a single module name with its params", item.Line)
+			}
+			for name, params := range entry {
+				c.Modules = append(c.Modules, name)
+				c.SetParams(name, params)
+			}
+		default:
+			return fmt.Errorf("line %d: expected a module name", item.Line)
+		}
+	}
+	if file.Version < enabledVersion {
+		for _, name := range legacyCoreModules {
+			if !contains(c.Modules, name) {
+				c.Modules = append(c.Modules, name)
+			}
+		}
+		sort.Strings(c.Modules)
+	}
+	return nil
 }
 
-type EnabledConfig struct {
-	Modules []string `yaml:"modules"`
+func (c EnabledConfig) MarshalYAML() (any, error) {
+	modules := make([]any, 0, len(c.Modules))
+	for _, name := range c.Modules {
+		if params := c.Params[name]; len(params) > 0 {
+			modules = append(modules, map[string]map[string]string{name: params})
+			continue
+		}
+		modules = append(modules, name)
+	}
+	return struct {
+		Version int   `yaml:"version"`
+		Modules []any `yaml:"modules"`
+	}{enabledVersion, modules}, nil
+}
+
+// SetParams merges params into the module's params; an empty value resets
+// the param to its default.
+func (c *EnabledConfig) SetParams(module string, params map[string]string) {
+	for key, value := range params {
+		if value == "" {
+			delete(c.Params[module], key)
+			continue
+		}
+		if c.Params == nil {
+			c.Params = map[string]map[string]string{}
+		}
+		if c.Params[module] == nil {
+			c.Params[module] = map[string]string{}
+		}
+		c.Params[module][key] = value
+	}
+	if len(c.Params[module]) == 0 {
+		delete(c.Params, module)
+	}
+}
+
+// SetModules replaces the module list, dropping params of modules that are
+// no longer enabled.
+func (c *EnabledConfig) SetModules(modules []string) {
+	c.Modules = modules
+	for name := range c.Params {
+		if !contains(modules, name) {
+			delete(c.Params, name)
+		}
+	}
 }
 
-func LoadEnabledModules(cfg EnvConfig) ([]string, error) {
+// ModuleParams resolves the params of every module in modules against the
+// catalog schema.
+func ModuleParams(cfg EnvConfig, catalog Catalog, modules []string) (map[string]map[string]any, error) {
+	conf, err := LoadEnabled(cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string]any{}
+	for _, name := range modules {
+		params, err := catalog[name].ResolveParams(conf.Params[name])
+		if err != nil {
+			return nil, err
+		}
+		out[name] = params
+	}
+	return out, nil
+}
+
+func LoadEnabledModules(ctx context.Context, cfg EnvConfig) ([]string, error) {
 	enabled, err := LoadEnabled(cfg)
 	if err != nil {
 		return nil, err
@@ -102,15 +150,24 @@ func LoadEnabledModules(cfg EnvConfig) ([]string, error) {
 		return []string{}, nil
 	}
 
+	catalog, err := loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	mods := make([]string, 0, len(enabled.Modules))
 	for _, m := range enabled.Modules {
-		if _, ok := ModuleCatalog[m]; ok {
-			mods = append(mods, m)
+		if _, ok := catalog[m]; !ok {
+			warnOnce(ctx, "enabled module %s of %s not found in the module search path; skipping it", m, cfg.EnvName)
+			continue
 		}
+		mods = append(mods, m)
 	}
-	mods = AddModuleDependencies(mods)
-	sort.Strings(mods)
-	return mods, nil
+	res, err := catalog.Resolve(mods)
+	if err != nil {
+		return nil, fmt.Errorf("resolve modules for %s: %w", cfg.EnvName, err)
+	}
+	return res.Modules, nil
 }
 
 func LoadEnabled(cfg EnvConfig) (EnabledConfig, error) {
@@ -135,36 +192,28 @@ func WriteEnabled(cfg EnvConfig, conf EnabledConfig) error {
 	return os.WriteFile(path, out, 0o640)
 }
 
-func AddModuleDependencies(modules []string) []string {
-	set := map[string]bool{}
-	for _, m := range modules {
-		set[m] = true
-	}
-	for _, m := range modules {
-		for _, dep := range ModuleDependencies[m] {
-			set[dep] = true
+// RemovedModules lists the modules, including implied dependencies, that the
+// before selection enables and the after selection no longer does.
+func RemovedModules(catalog Catalog, before, after []string) []string {
+	resolve := func(modules []string) []string {
+		var known []string
+		for _, m := range modules {
+			if _, ok := catalog[m]; ok {
+				known = append(known, m)
+			}
 		}
+		res, err := catalog.Resolve(known)
+		if err != nil {
+			return known
+		}
+		return res.Modules
 	}
-	out := make([]string, 0, len(set))
-	for k := range set {
-		out = append(out, k)
-	}
-	return out
-}
-
-func SortedModuleNames() []string {
-	names := make([]string, 0, len(ModuleCatalog))
-	for name := range ModuleCatalog {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-	return names
-}
-
-func sortedModulePorts(name string) string {
-	m, ok := ModuleCatalog[name]
-	if !ok || len(m.Ports) == 0 {
-		return "-"
+	kept := resolve(after)
+	var removed []string
+	for _, m := range resolve(before) {
+		if !contains(kept, m) {
+			removed = append(removed, m)
+		}
 	}
-	return strings.Join(m.Ports, ",")
+	return removed
 }
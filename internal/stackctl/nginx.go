@@ -1,49 +1,124 @@
 package stackctl
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+// writeNginxConfs renders <module>/nginx/*.conf of every enabled module into
+// the environment's nginx conf.d and removes the confs of disabled modules.
+// Apps from apps.yml with a subdomain get a vhost of their own.
 func writeNginxConfs(cfg EnvConfig, modules []string) error {
 	confDir := filepath.Join(cfg.EnvDir, "nginx", "conf.d")
 	if err := ensureDir(confDir, 0o750); err != nil {
 		return err
 	}
+	files, err := renderNginxConfs(cfg, modules)
+	if err != nil {
+		return err
+	}
+	for _, file := range sortedKeys(files) {
+		target := filepath.Join(confDir, file)
+		if files[file] == nil {
+			_ = os.Remove(target)
+			continue
+		}
+		if err := os.WriteFile(target, files[file], 0o640); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	templates := findTemplatesDir()
+// renderNginxConfs returns the conf.d files keyed by name. A nil entry is a
+// conf stackctl manages that should no longer exist.
+func renderNginxConfs(cfg EnvConfig, modules []string) (map[string][]byte, error) {
+	catalog, err := LoadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	params, err := ModuleParams(cfg, catalog, modules)
+	if err != nil {
+		return nil, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil, err
+	}
 	data := cfg.RenderData()
+	data.Colors = st.Colors
 
-	render := func(templateName, targetName string) error {
-		inPath := filepath.Join(templates, "nginx", templateName)
-		text, err := renderFile(inPath, data)
+	out := map[string][]byte{}
+	owner := map[string]string{}
+	for _, name := range catalog.Names() {
+		files, err := filepath.Glob(filepath.Join(catalog[name].Dir, "nginx", "*.conf"))
 		if err != nil {
-			return fmt.Errorf("render nginx %s: %w", templateName, err)
+			return nil, err
+		}
+		for _, inPath := range files {
+			file := filepath.Base(inPath)
+			if other, ok := owner[file]; ok {
+				return nil, fmt.Errorf("nginx %s is provided by both %s and %s", file, other, name)
+			}
+			owner[file] = name
+
+			if !contains(modules, name) {
+				out[file] = nil
+				continue
+			}
+			data.Module = ModuleData{Name: name, Params: params[name]}
+			text, err := renderFile(inPath, data)
+			if err != nil {
+				return nil, fmt.Errorf("render nginx %s: %w", file, err)
+			}
+			out[file] = []byte(text)
 		}
-		return os.WriteFile(filepath.Join(confDir, targetName), []byte(text), 0o640)
 	}
 
-	if err := render("app.conf", "app.conf"); err != nil {
-		return err
+	apps, err := LoadApps(cfg)
+	if err != nil {
+		return nil, err
 	}
-	if err := render("api.conf", "api.conf"); err != nil {
-		return err
+	vhosts, err := renderAppVhosts(cfg, apps)
+	if err != nil {
+		return nil, err
 	}
-	if err := render("kc.conf", "kc.conf"); err != nil {
-		return err
+	for file, text := range vhosts {
+		out[file] = text
 	}
+	return out, nil
+}
+
+var serverNameRegex = regexp.MustCompile(`(?m)^\s*server_name\s+([^;]+);`)
 
-	optional := map[string]string{"grafana": "grafana.conf", "kuma": "kuma.conf"}
-	for module, file := range optional {
-		path := filepath.Join(confDir, file)
-		if contains(modules, module) {
-			if err := render(file, file); err != nil {
-				return err
+// envVhosts returns the host names in the server_name of the nginx confs the
+// enabled modules and published apps render, sorted. Catch-all and wildcard
+// names are left out.
+func envVhosts(ctx context.Context, cfg EnvConfig) ([]string, error) {
+	modules, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	files, err := renderNginxConfs(cfg, modules)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, text := range files {
+		for _, m := range serverNameRegex.FindAllSubmatch(text, -1) {
+			for _, host := range strings.Fields(string(m[1])) {
+				if host == "_" || strings.ContainsAny(host, "*~") || strings.HasPrefix(host, ".") || contains(hosts, host) {
+					continue
+				}
+				hosts = append(hosts, host)
 			}
-		} else {
-			_ = os.Remove(path)
 		}
 	}
-	return nil
+	sort.Strings(hosts)
+	return hosts, nil
 }
@@ -0,0 +1,190 @@
+package stackctl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModuleParam declares a setting a module template can read as
+// {{.Module.Params.<name>}}.
+type ModuleParam struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Default     string   `yaml:"default"`
+	Enum        []string `yaml:"enum"`
+	Description string   `yaml:"description"`
+	// Pattern is a regular expression a value must match as a whole. String
+	// params that end up in a compose or nginx file need one.
+	Pattern string `yaml:"pattern"`
+}
+
+// ModuleData is the module-specific part of RenderData.
+type ModuleData struct {
+	Name   string
+	Params map[string]any
+}
+
+var paramTypes = []string{"string", "int", "bool", "duration"}
+
+func validateParamSchema(module string, params []ModuleParam) error {
+	seen := map[string]bool{}
+	for i := range params {
+		p := &params[i]
+		if p.Name == "" {
+			return fmt.Errorf("module %s: every param needs a name", module)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("module %s: duplicate param %s", module, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Type == "" {
+			p.Type = "string"
+		}
+		if !contains(paramTypes, p.Type) {
+			return fmt.Errorf("module %s: param %s has unknown type %q (use %s)", module, p.Name, p.Type, strings.Join(paramTypes, ", "))
+		}
+		if _, err := p.pattern(); err != nil {
+			return fmt.Errorf("module %s: pattern of param %s: %w", module, p.Name, err)
+		}
+		if _, err := p.parse(p.Default); err != nil {
+			return fmt.Errorf("module %s: default of param %s: %w", module, p.Name, err)
+		}
+	}
+	return nil
+}
+
+// pattern compiles Pattern anchored at both ends; nil when there is none.
+func (p ModuleParam) pattern() (*regexp.Regexp, error) {
+	if p.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + p.Pattern + `)$`)
+}
+
+// parse converts a raw value to the param's type.
+func (p ModuleParam) parse(raw string) (any, error) {
+	if len(p.Enum) > 0 && !contains(p.Enum, raw) {
+		return nil, fmt.Errorf("%q is not one of %s", raw, strings.Join(p.Enum, ", "))
+	}
+	re, err := p.pattern()
+	if err != nil {
+		return nil, err
+	}
+	if re != nil && !re.MatchString(raw) {
+		return nil, fmt.Errorf("%q does not match %s", raw, p.Pattern)
+	}
+	switch p.Type {
+	case "int":
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return b, nil
+	case "duration":
+		if _, err := time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("%q is not a duration (e.g. 12h, 30m)", raw)
+		}
+		return raw, nil
+	default:
+		return raw, nil
+	}
+}
+
+func (m ModuleInfo) param(name string) (ModuleParam, bool) {
+	for _, p := range m.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ModuleParam{}, false
+}
+
+// ResolveParams checks values against the module's schema and returns every
+// declared param, with defaults filled in, converted to its type.
+func (m ModuleInfo) ResolveParams(values map[string]string) (map[string]any, error) {
+	for key := range values {
+		if _, ok := m.param(key); !ok {
+			return nil, fmt.Errorf("module %s has no param %q (known: %s)", m.Name, key, m.paramNames())
+		}
+	}
+	out := map[string]any{}
+	for _, p := range m.Params {
+		raw, ok := values[p.Name]
+		if !ok {
+			raw = p.Default
+		}
+		v, err := p.parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("module %s param %s: %w", m.Name, p.Name, err)
+		}
+		out[p.Name] = v
+	}
+	return out, nil
+}
+
+func (m ModuleInfo) paramNames() string {
+	if len(m.Params) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(m.Params))
+	for _, p := range m.Params {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseSetFlags turns key=value pairs into a map.
+func ParseSetFlags(pairs []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --set %q: expected key=value", pair)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// ParamLines describes the params of the enabled modules that differ from
+// their defaults, one "module key=value" per line.
+func ParamLines(conf EnabledConfig) []string {
+	var lines []string
+	for _, module := range sortedKeys(conf.Params) {
+		params := conf.Params[module]
+		for _, key := range sortedKeys(params) {
+			lines = append(lines, fmt.Sprintf("%-14s %s=%s", module, key, params[key]))
+		}
+	}
+	return lines
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
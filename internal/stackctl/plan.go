@@ -0,0 +1,239 @@
+package stackctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// plannedFile is a file apply would write. A nil Content means apply would
+// remove it.
+type plannedFile struct {
+	Path    string
+	Content []byte
+}
+
+// RunPlan renders everything apply would write into a temporary directory
+// and prints a unified diff against the environment, followed by the service
+// and systemd unit changes. It changes nothing on disk or in Docker.
+func RunPlan(ctx context.Context, cfg EnvConfig) error {
+	modules, err := LoadEnabledModules(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	ports, err := planPorts(cfg, modules)
+	if err != nil {
+		return err
+	}
+	compose, err := renderCompose(cfg, modules, ports)
+	if err != nil {
+		return err
+	}
+	confs, err := renderNginxConfs(cfg, modules)
+	if err != nil {
+		return err
+	}
+	units, err := renderSystemdFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	files := []plannedFile{{Path: "compose.yml", Content: compose}}
+	for _, name := range sortedKeys(confs) {
+		files = append(files, plannedFile{Path: filepath.Join("nginx", "conf.d", name), Content: confs[name]})
+	}
+	for _, name := range sortedKeys(units) {
+		files = append(files, plannedFile{Path: filepath.Join("systemd", name), Content: units[name]})
+	}
+
+	tmp, err := os.MkdirTemp("", "stackctl-plan-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	changed := 0
+	for _, f := range files {
+		current := filepath.Join(cfg.EnvDir, f.Path)
+		if f.Content == nil {
+			if _, err := os.Stat(current); err != nil {
+				continue
+			}
+		}
+		planned := os.DevNull
+		if f.Content != nil {
+			planned = filepath.Join(tmp, f.Path)
+			if err := ensureDir(filepath.Dir(planned), 0o700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(planned, f.Content, 0o600); err != nil {
+				return err
+			}
+		}
+		diff, err := diffFiles(ctx, current, planned, f.Path)
+		if err != nil {
+			return err
+		}
+		if diff != "" {
+			changed++
+			fmt.Fprint(Out(ctx), diff)
+		}
+	}
+	if changed == 0 {
+		fmt.Fprintln(Out(ctx), "no file changes")
+	} else {
+		fmt.Fprintf(Out(ctx), "\n%d file(s) would change\n", changed)
+	}
+
+	fmt.Fprintln(Out(ctx), "\nservices:")
+	if err := printServicePlan(ctx, cfg, modules, filepath.Join(tmp, "compose.yml")); err != nil {
+		fmt.Fprintf(Out(ctx), "  unavailable: %v\n", err)
+	}
+
+	fmt.Fprintln(Out(ctx), "\nsystemd units:")
+	printUnitPlan(ctx, units)
+
+	if hooks := plannedHooks(modules); len(hooks) > 0 {
+		fmt.Fprintln(Out(ctx), "\nhooks apply would run:")
+		for _, line := range hooks {
+			fmt.Fprintf(Out(ctx), "  %s\n", line)
+		}
+	}
+	return nil
+}
+
+// diffFiles runs diff -u between current and planned, both labelled with
+// name. A missing current file diffs as empty.
+func diffFiles(ctx context.Context, current, planned, name string) (string, error) {
+	out, err := RunCmdOutput(ctx, "diff", "-u", "-N", "--label", "a/"+name, "--label", "b/"+name, current, planned)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return out, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("diff %s: %w", name, err)
+	}
+	return "", nil
+}
+
+// printServicePlan compares the config hash compose computes for each
+// planned service with the hash label of the project's existing containers.
+func printServicePlan(ctx context.Context, cfg EnvConfig, modules []string, composeFile string) error {
+	args := []string{
+		"compose",
+		"-f", composeFile,
+		"-f", filepath.Join(cfg.EnvDir, "compose.override.yml"),
+		"--env-file", filepath.Join(cfg.EnvDir, ".env"),
+		"--project-directory", cfg.EnvDir,
+		"-p", cfg.EnvName,
+	}
+	for _, module := range modules {
+		args = append(args, "--profile", module)
+	}
+	args = append(args, "config", "--hash", "*")
+	out, err := RunCmdCapture(ctx, "docker", args...)
+	if err != nil {
+		return fmt.Errorf("docker compose config: %s", commandError(out, err))
+	}
+	planned := parseServiceHashes(out)
+
+	out, err = RunCmdCapture(ctx, "docker", "ps", "-a",
+		"--filter", "label=com.docker.compose.project="+cfg.EnvName,
+		"--format", `{{.Label "com.docker.compose.service"}} {{.Label "com.docker.compose.config-hash"}}`)
+	if err != nil {
+		return fmt.Errorf("docker ps: %s", commandError(out, err))
+	}
+	running := parseServiceHashes(out)
+
+	var lines []string
+	for name, hash := range planned {
+		current, ok := running[name]
+		switch {
+		case !ok:
+			lines = append(lines, "+ "+name+" (create)")
+		case current != hash:
+			lines = append(lines, "~ "+name+" (recreate)")
+		}
+	}
+	for name := range running {
+		if _, ok := planned[name]; !ok {
+			lines = append(lines, "- "+name+" (remove)")
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	if len(lines) == 0 {
+		fmt.Fprintln(Out(ctx), "  no changes")
+	}
+	for _, line := range lines {
+		fmt.Fprintf(Out(ctx), "  %s\n", line)
+	}
+	return nil
+}
+
+// commandError prefers a command's own output over its exit status.
+func commandError(out string, err error) string {
+	if msg := strings.TrimSpace(out); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
+
+func parseServiceHashes(out string) map[string]string {
+	hashes := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			hashes[fields[0]] = fields[1]
+		}
+	}
+	return hashes
+}
+
+// printUnitPlan compares the rendered units with the installed ones. Units
+// are only installed when apply runs as root.
+func printUnitPlan(ctx context.Context, units map[string][]byte) {
+	changes := 0
+	for _, name := range sortedKeys(units) {
+		installed, err := os.ReadFile(filepath.Join(systemdUnitDir, name))
+		switch {
+		case err != nil:
+			fmt.Fprintf(Out(ctx), "  + %s (install)\n", name)
+		case !bytes.Equal(installed, units[name]):
+			fmt.Fprintf(Out(ctx), "  ~ %s (update)\n", name)
+		default:
+			continue
+		}
+		changes++
+	}
+	if changes == 0 {
+		fmt.Fprintln(Out(ctx), "  no changes")
+	} else if os.Geteuid() != 0 {
+		fmt.Fprintln(Out(ctx), "  (units are installed only when apply runs as root)")
+	}
+}
+
+func plannedHooks(modules []string) []string {
+	catalog, err := LoadCatalog()
+	if err != nil {
+		return nil
+	}
+	res, err := catalog.Resolve(modules)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, phase := range []string{HookPreApply, HookPostApply} {
+		for _, name := range res.Order {
+			for _, h := range catalog[name].Hooks[phase] {
+				lines = append(lines, fmt.Sprintf("%s %s: %s", name, phase, h.label()))
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,131 @@
+package stackctl
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	LoopbackHost = "127.0.0.1"
+
+	// Fallback range used when a module's default port is already allocated
+	// to another environment.
+	portRangeStart = 20000
+	portRangeEnd   = 29999
+)
+
+// AllocatePorts assigns a host port to every port of the given modules and
+// records the result in the environment state. Existing allocations are kept
+// as long as no other environment has claimed the same port; ports of modules
+// that are no longer enabled are released.
+func AllocatePorts(cfg EnvConfig, modules []string) (map[string]map[string]int, error) {
+	allocated, err := planPorts(cfg, modules)
+	if err != nil {
+		return nil, err
+	}
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st.Ports = allocated
+	if err := WriteState(cfg, st); err != nil {
+		return nil, err
+	}
+	return allocated, nil
+}
+
+// planPorts works out the allocation AllocatePorts would record without
+// writing it.
+func planPorts(cfg EnvConfig, modules []string) (map[string]map[string]int, error) {
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := LoadCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	taken := map[int]string{}
+	for _, env := range DetectEnvironments() {
+		if env == cfg.EnvName {
+			continue
+		}
+		other, err := LoadEnvConfig(env)
+		if err != nil {
+			continue
+		}
+		otherState, err := LoadState(other)
+		if err != nil {
+			continue
+		}
+		for module, ports := range otherState.Ports {
+			for _, port := range ports {
+				taken[port] = env + "/" + module
+			}
+		}
+	}
+
+	sorted := append([]string{}, modules...)
+	sort.Strings(sorted)
+
+	allocated := map[string]map[string]int{}
+	for _, module := range sorted {
+		info, ok := catalog[module]
+		if !ok || len(info.Ports) == 0 {
+			continue
+		}
+		allocated[module] = map[string]int{}
+		for _, p := range info.Ports {
+			port, ok := st.Ports[module][p.Name]
+			if !ok || taken[port] != "" {
+				port, err = freePort(p.Default, taken)
+				if err != nil {
+					return nil, fmt.Errorf("allocate port %s/%s: %w", module, p.Name, err)
+				}
+			}
+			taken[port] = cfg.EnvName + "/" + module
+			allocated[module][p.Name] = port
+		}
+	}
+	return allocated, nil
+}
+
+func freePort(preferred int, taken map[int]string) (int, error) {
+	if preferred > 0 && taken[preferred] == "" {
+		return preferred, nil
+	}
+	for port := portRangeStart; port <= portRangeEnd; port++ {
+		if taken[port] == "" {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port in %d-%d", portRangeStart, portRangeEnd)
+}
+
+// PortLines formats the allocated ports of an environment for display, one
+// "module name host:port" entry per published port.
+func PortLines(cfg EnvConfig) []string {
+	st, err := LoadState(cfg)
+	if err != nil {
+		return nil
+	}
+	modules := make([]string, 0, len(st.Ports))
+	for module := range st.Ports {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+
+	var lines []string
+	for _, module := range modules {
+		names := make([]string, 0, len(st.Ports[module]))
+		for name := range st.Ports[module] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%-14s %-10s %s:%d", module, name, LoopbackHost, st.Ports[module][name]))
+		}
+	}
+	return lines
+}
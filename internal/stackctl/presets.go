@@ -0,0 +1,63 @@
+package stackctl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Preset is a named starting set of modules for a new environment.
+type Preset struct {
+	Description string   `yaml:"description"`
+	Modules     []string `yaml:"modules"`
+}
+
+// Presets is templates/presets.yml.
+type Presets struct {
+	Default string            `yaml:"default"`
+	Presets map[string]Preset `yaml:"presets"`
+}
+
+func LoadPresets() (Presets, error) {
+	b, err := os.ReadFile(filepath.Join(findTemplatesDir(), "presets.yml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Presets{Default: "full", Presets: map[string]Preset{"full": {Modules: legacyCoreModules}}}, nil
+	}
+	if err != nil {
+		return Presets{}, err
+	}
+	var p Presets
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return Presets{}, fmt.Errorf("parse presets: %w", err)
+	}
+	if _, ok := p.Presets[p.Default]; !ok {
+		return Presets{}, fmt.Errorf("default preset %q is not defined", p.Default)
+	}
+	return p, nil
+}
+
+func (p Presets) Names() []string {
+	return sortedKeys(p.Presets)
+}
+
+// Modules returns the modules of the named preset, or of the default preset
+// when name is empty, with dependencies resolved against catalog.
+func (p Presets) Modules(catalog Catalog, name string) ([]string, error) {
+	if name == "" {
+		name = p.Default
+	}
+	preset, ok := p.Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(p.Names(), ", "))
+	}
+	res, err := catalog.Resolve(preset.Modules)
+	if err != nil {
+		return nil, fmt.Errorf("preset %s: %w", name, err)
+	}
+	return res.Modules, nil
+}
@@ -0,0 +1,197 @@
+package stackctl
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PromoteParts are the parts of an environment promote can copy.
+var PromoteParts = []string{"images", "modules", "params"}
+
+// PromoteChange is one difference between the source and the target of a
+// promotion. From is the target's current value, To the source's.
+type PromoteChange struct {
+	Part   string
+	Module string
+	Key    string
+	From   string
+	To     string
+}
+
+func (c PromoteChange) String() string {
+	show := func(v string) string {
+		if v == "" {
+			return "(unset)"
+		}
+		return v
+	}
+	switch c.Part {
+	case "modules":
+		if c.To == "" {
+			return fmt.Sprintf("modules  - %s", c.Module)
+		}
+		return fmt.Sprintf("modules  + %s", c.Module)
+	case "params":
+		return fmt.Sprintf("params   %s %s: %s -> %s", c.Module, c.Key, show(c.From), show(c.To))
+	}
+	return fmt.Sprintf("images   %s: %s -> %s", c.Key, show(c.From), show(c.To))
+}
+
+// isImageKey reports whether a .env key sets an image or its tag, the only
+// .env keys promote copies.
+func isImageKey(key string) bool {
+	if IsSecretKey(key) || IsDomainKey(key) {
+		return false
+	}
+	for _, suffix := range []string{"_IMAGE", "_TAG", "_VERSION"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanPromotion lists how the image keys, enabled modules and module params
+// of to differ from from. Secrets and domain keys are never compared.
+func PlanPromotion(from, to EnvConfig) ([]PromoteChange, error) {
+	fromVars, err := dotEnvVars(from)
+	if err != nil {
+		return nil, err
+	}
+	toVars, err := dotEnvVars(to)
+	if err != nil {
+		return nil, err
+	}
+	fromConf, err := LoadEnabled(from)
+	if err != nil {
+		return nil, err
+	}
+	toConf, err := LoadEnabled(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []PromoteChange
+	for _, key := range sortedKeys(fromVars) {
+		if isImageKey(key) && fromVars[key] != toVars[key] {
+			changes = append(changes, PromoteChange{Part: "images", Key: key, From: toVars[key], To: fromVars[key]})
+		}
+	}
+
+	for _, m := range fromConf.Modules {
+		if !contains(toConf.Modules, m) {
+			changes = append(changes, PromoteChange{Part: "modules", Module: m, To: m})
+		}
+	}
+	for _, m := range toConf.Modules {
+		if !contains(fromConf.Modules, m) {
+			changes = append(changes, PromoteChange{Part: "modules", Module: m, From: m})
+		}
+	}
+
+	for _, m := range fromConf.Modules {
+		keys := map[string]bool{}
+		for k := range fromConf.Params[m] {
+			keys[k] = true
+		}
+		for k := range toConf.Params[m] {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			if IsSecretKey(k) || IsDomainKey(k) {
+				continue
+			}
+			if v := fromConf.Params[m][k]; v != toConf.Params[m][k] {
+				changes = append(changes, PromoteChange{Part: "params", Module: m, Key: k, From: toConf.Params[m][k], To: v})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// SelectPromotion keeps the changes of the given parts. Param changes of
+// modules the target will not have enabled are dropped.
+func SelectPromotion(from, to EnvConfig, changes []PromoteChange, parts []string) ([]PromoteChange, error) {
+	conf, err := LoadEnabled(to)
+	if err != nil {
+		return nil, err
+	}
+	modules := conf.Modules
+	if contains(parts, "modules") {
+		if conf, err = LoadEnabled(from); err != nil {
+			return nil, err
+		}
+		modules = conf.Modules
+	}
+	var selected []PromoteChange
+	for _, c := range changes {
+		if !contains(parts, c.Part) || (c.Part == "params" && !contains(modules, c.Module)) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
+
+// ApplyPromotion writes changes, as returned by SelectPromotion, to the
+// target's .env and enabled.yml. It does not apply the target.
+func ApplyPromotion(ctx context.Context, from, to EnvConfig, changes []PromoteChange, parts []string) error {
+	if len(changes) == 0 {
+		return fmt.Errorf("nothing to promote from %s to %s", from.EnvName, to.EnvName)
+	}
+	toConf, err := LoadEnabled(to)
+	if err != nil {
+		return err
+	}
+	if contains(parts, "modules") {
+		fromConf, err := LoadEnabled(from)
+		if err != nil {
+			return err
+		}
+		modules := append([]string(nil), fromConf.Modules...)
+		sort.Strings(modules)
+		toConf.SetModules(modules)
+	}
+
+	vars := map[string]string{}
+	var applied []string
+	for _, c := range changes {
+		switch c.Part {
+		case "images":
+			vars[c.Key] = c.To
+		case "params":
+			toConf.SetParams(c.Module, map[string]string{c.Key: c.To})
+		}
+		applied = append(applied, strings.Join(strings.Fields(c.String()), " "))
+	}
+
+	catalog, err := loadCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := catalog.Resolve(toConf.Modules); err != nil {
+		return fmt.Errorf("promoted modules for %s: %w", to.EnvName, err)
+	}
+	for _, m := range toConf.Modules {
+		if _, err := catalog[m].ResolveParams(toConf.Params[m]); err != nil {
+			return fmt.Errorf("promoted params for %s: %w", to.EnvName, err)
+		}
+	}
+
+	if len(vars) > 0 {
+		if err := WriteDotEnv(filepath.Join(to.EnvDir, ".env"), vars); err != nil {
+			return err
+		}
+	}
+	if contains(parts, "modules") || contains(parts, "params") {
+		if err := WriteEnabled(to, toConf); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(Out(ctx), "promoted %d change(s) from %s to %s\n", len(applied), from.EnvName, to.EnvName)
+	fmt.Fprintf(Out(ctx), "run: stackctl plan --env %s && stackctl apply --env %s\n", to.EnvName, to.EnvName)
+	return nil
+}
@@ -2,6 +2,7 @@ package stackctl
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,9 +17,39 @@ type RenderData struct {
 	StackRoot   string
 	DataRoot    string
 	BackupRoot  string
+	Edge        bool
+	EdgeNetwork string
+	Ports       map[string]map[string]int
+	// NginxDepends lists the services nginx depends on, collected from the
+	// enabled modules.
+	NginxDepends []string
+	// Colors are the active colors of blue/green services, from the state.
+	Colors map[string]string
+	// Vhosts are the host names the environment's nginx serves for the
+	// enabled modules and published apps. They are only set for hooks.
+	Vhosts []string
+	// Module is set while rendering a module's own templates.
+	Module ModuleData
 }
 
-func renderFile(path string, data RenderData) (string, error) {
+// Port returns the host port allocated to a module port, for use in
+// templates as {{.Port "grafana" "http"}}.
+func (d RenderData) Port(module, name string) (int, error) {
+	port, ok := d.Ports[module][name]
+	if !ok {
+		return 0, fmt.Errorf("no port allocated for %s/%s", module, name)
+	}
+	return port, nil
+}
+
+// Upstream returns the service name nginx proxies to for service: its green
+// twin while green is active, for use in templates as
+// {{.Upstream "backend"}}.
+func (d RenderData) Upstream(service string) string {
+	return colorService(service, d.Colors[service])
+}
+
+func renderFile(path string, data any) (string, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
@@ -26,8 +57,13 @@ func renderFile(path string, data RenderData) (string, error) {
 	return renderString(string(content), data)
 }
 
-func renderString(content string, data RenderData) (string, error) {
-	tmpl, err := template.New("").Option("missingkey=error").Parse(content)
+// templateFuncs are the functions templates may call besides the builtins.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func renderString(content string, data any) (string, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(content)
 	if err != nil {
 		return "", err
 	}
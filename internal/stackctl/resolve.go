@@ -0,0 +1,150 @@
+package stackctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resolution is the outcome of resolving a set of requested modules against
+// the catalog.
+type Resolution struct {
+	// Modules is the full set to enable, sorted by name.
+	Modules []string
+	// Order lists Modules with every module after its dependencies.
+	Order []string
+	// Added maps each module pulled in as a dependency to the module that
+	// first required it.
+	Added map[string]string
+	// Recommended lists modules recommended by the set that are not in it.
+	Recommended []string
+}
+
+// Resolve computes the transitive closure of requested over depends, then
+// checks it for unknown modules, dependency cycles and conflicts.
+func (c Catalog) Resolve(requested []string) (Resolution, error) {
+	res := Resolution{Added: map[string]string{}}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+
+	var visit func(name, requiredBy string) error
+	visit = func(name, requiredBy string) error {
+		info, ok := c[name]
+		if !ok {
+			if requiredBy != "" {
+				return fmt.Errorf("module %s depends on unknown module %s", requiredBy, name)
+			}
+			return fmt.Errorf("unknown module: %s", name)
+		}
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, s := range stack {
+				if s == name {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, stack[start:]...), name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range info.Depends {
+			if _, seen := res.Added[dep]; !seen && !contains(requested, dep) {
+				res.Added[dep] = name
+			}
+			if err := visit(dep, name); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		res.Order = append(res.Order, name)
+		return nil
+	}
+
+	sorted := append([]string{}, requested...)
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if err := visit(name, ""); err != nil {
+			return Resolution{}, err
+		}
+	}
+
+	res.Modules = append([]string{}, res.Order...)
+	sort.Strings(res.Modules)
+
+	if err := c.checkConflicts(res.Modules); err != nil {
+		return Resolution{}, err
+	}
+
+	for _, name := range res.Modules {
+		for _, rec := range c[name].Recommends {
+			if _, ok := c[rec]; ok && !contains(res.Modules, rec) && !contains(res.Recommended, rec) {
+				res.Recommended = append(res.Recommended, rec)
+			}
+		}
+	}
+	sort.Strings(res.Recommended)
+	return res, nil
+}
+
+// checkConflicts reports the first pair of modules in the set that conflict,
+// either by name or because both provide the same role.
+func (c Catalog) checkConflicts(modules []string) error {
+	providers := map[string]string{}
+	for _, name := range modules {
+		for _, role := range c[name].Provides {
+			if other, ok := providers[role]; ok {
+				return fmt.Errorf("modules %s and %s both provide %s; enable only one", other, name, role)
+			}
+			providers[role] = name
+		}
+	}
+
+	for _, name := range modules {
+		for _, target := range c[name].Conflicts {
+			if target != name && contains(modules, target) {
+				return fmt.Errorf("module %s conflicts with %s", name, target)
+			}
+			if other, ok := providers[target]; ok && other != name {
+				return fmt.Errorf("module %s conflicts with %s (provides %s)", name, other, target)
+			}
+		}
+	}
+	return nil
+}
+
+// EnabledDependents lists the modules of the resolved enabled set that
+// depend on name, directly or transitively.
+func (c Catalog) EnabledDependents(name string, enabled []string) []string {
+	var out []string
+	for _, mod := range enabled {
+		if mod != name && c.dependsOn(mod, name, map[string]bool{}) {
+			out = append(out, mod)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c Catalog) dependsOn(mod, target string, seen map[string]bool) bool {
+	if seen[mod] {
+		return false
+	}
+	seen[mod] = true
+	for _, dep := range c[mod].Depends {
+		if dep == target || c.dependsOn(dep, target, seen) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package stackctl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvState is bookkeeping stackctl keeps for an environment between runs. It
+// is stored in <env dir>/state.yml and is not meant to be edited by hand.
+type EnvState struct {
+	Ports map[string]map[string]int `yaml:"ports,omitempty"`
+	// AppliedHash is the applyHash of the last successful apply.
+	AppliedHash string `yaml:"applied_hash,omitempty"`
+	// Generation is the generation the environment currently runs.
+	Generation int `yaml:"generation,omitempty"`
+	// Colors holds the active color of blue/green services that run green;
+	// the others run blue.
+	Colors map[string]string `yaml:"colors,omitempty"`
+}
+
+func LoadState(cfg EnvConfig) (EnvState, error) {
+	b, err := os.ReadFile(filepath.Join(cfg.EnvDir, "state.yml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return EnvState{}, nil
+	}
+	if err != nil {
+		return EnvState{}, err
+	}
+	var st EnvState
+	if err := yaml.Unmarshal(b, &st); err != nil {
+		return EnvState{}, err
+	}
+	return st, nil
+}
+
+func WriteState(cfg EnvConfig, st EnvState) error {
+	out, err := yaml.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cfg.EnvDir, "state.yml"), out, 0o640)
+}
@@ -1,58 +1,78 @@
 package stackctl
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 )
 
-func writeSystemdFiles(cfg EnvConfig) error {
-	templates := findTemplatesDir()
-	data := cfg.RenderData()
+// systemdUnitDir is where units are installed when stackctl runs as root.
+// Tests, which may run as root, point it at a temporary directory.
+var systemdUnitDir = "/etc/systemd/system"
+
+func writeSystemdFiles(ctx context.Context, cfg EnvConfig) error {
 	targetDir := filepath.Join(cfg.EnvDir, "systemd")
 	if err := ensureDir(targetDir, 0o750); err != nil {
 		return err
 	}
-
-	type filePair struct {
-		in  string
-		out string
+	units, err := renderSystemdFiles(cfg)
+	if err != nil {
+		return err
 	}
-	files := []filePair{
-		{in: "stackctl-env.service", out: fmt.Sprintf("stackctl-%s.service", cfg.EnvName)},
-		{in: "stackctl-backup.service", out: fmt.Sprintf("stackctl-backup-%s.service", cfg.EnvName)},
-		{in: "stackctl-backup.timer", out: fmt.Sprintf("stackctl-backup-%s.timer", cfg.EnvName)},
+	names := sortedKeys(units)
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(targetDir, name), units[name], 0o644); err != nil {
+			return err
+		}
 	}
 
-	for _, pair := range files {
-		inPath := filepath.Join(templates, "systemd", pair.in)
-		text, err := renderFile(inPath, data)
+	if os.Geteuid() == 0 {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(targetDir, name))
+		}
+		return installSystemdUnits(ctx, cfg, paths)
+	}
+	return nil
+}
+
+// installSystemdUnits copies unit files into systemdUnitDir and enables the
+// environment's service and backup timer. It needs root.
+func installSystemdUnits(ctx context.Context, cfg EnvConfig, paths []string) error {
+	for _, src := range paths {
+		b, err := os.ReadFile(src)
 		if err != nil {
-			return fmt.Errorf("render systemd %s: %w", pair.in, err)
+			return err
 		}
-		target := filepath.Join(targetDir, pair.out)
-		if err := os.WriteFile(target, []byte(text), 0o644); err != nil {
+		if err := os.WriteFile(filepath.Join(systemdUnitDir, filepath.Base(src)), b, 0o644); err != nil {
 			return err
 		}
 	}
+	_ = RunCmdStream(ctx, "systemctl", "daemon-reload")
+	_ = RunCmdStream(ctx, "systemctl", "enable", fmt.Sprintf("stackctl-%s.service", cfg.EnvName))
+	_ = RunCmdStream(ctx, "systemctl", "enable", fmt.Sprintf("stackctl-backup-%s.timer", cfg.EnvName))
+	return nil
+}
 
-	if os.Geteuid() == 0 {
-		for _, pair := range files {
-			src := filepath.Join(targetDir, pair.out)
-			dst := filepath.Join("/etc/systemd/system", pair.out)
-			b, err := os.ReadFile(src)
-			if err != nil {
-				return err
-			}
-			if err := os.WriteFile(dst, b, 0o644); err != nil {
-				return err
-			}
+// renderSystemdFiles returns the environment's units keyed by unit name.
+func renderSystemdFiles(cfg EnvConfig) (map[string][]byte, error) {
+	templates := findTemplatesDir()
+	data := cfg.RenderData()
+	files := map[string]string{
+		"stackctl-env.service":    fmt.Sprintf("stackctl-%s.service", cfg.EnvName),
+		"stackctl-backup.service": fmt.Sprintf("stackctl-backup-%s.service", cfg.EnvName),
+		"stackctl-backup.timer":   fmt.Sprintf("stackctl-backup-%s.timer", cfg.EnvName),
+	}
+	units := map[string][]byte{}
+	for in, out := range files {
+		text, err := renderFile(filepath.Join(templates, "systemd", in), data)
+		if err != nil {
+			return nil, fmt.Errorf("render systemd %s: %w", in, err)
 		}
-		_ = RunCmdStream("systemctl", "daemon-reload")
-		_ = RunCmdStream("systemctl", "enable", fmt.Sprintf("stackctl-%s.service", cfg.EnvName))
-		_ = RunCmdStream("systemctl", "enable", fmt.Sprintf("stackctl-backup-%s.timer", cfg.EnvName))
+		units[out] = []byte(text)
 	}
-	return nil
+	return units, nil
 }
 
 func writeBackupScript(cfg EnvConfig) error {
@@ -1,15 +1,29 @@
 package stackctl
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 func ensureDir(path string, mode os.FileMode) error {
 	return os.MkdirAll(path, mode)
 }
 
+// sleep waits for d, returning early with ctx's error if it is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func DirExists(path string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -18,6 +32,19 @@ func DirExists(path string) bool {
 	return info.IsDir()
 }
 
+// isLocalPath reports whether p, joined to a directory, names something
+// below it: p is relative, not empty, and neither "." nor climbing out with
+// "..".
+func isLocalPath(p string) bool {
+	return filepath.IsLocal(p) && filepath.Clean(p) != "."
+}
+
+// pathWithin reports whether path is below root.
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && isLocalPath(rel)
+}
+
 func contains(items []string, needle string) bool {
 	for _, item := range items {
 		if item == needle {
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -201,7 +202,9 @@ func (m *configEditorModel) updateEdit(msg tea.Msg) (screenModel, tea.Cmd) {
 func (m *configEditorModel) save() tea.Cmd {
 	return func() tea.Msg {
 		envPath := filepath.Join(m.cfg.EnvDir, ".env")
-		err := stackctl.WriteDotEnv(envPath, m.vars)
+		err := withEnvLock(m.cfg, "stackctl config (save)", func(ctx context.Context) error {
+			return stackctl.WriteDotEnv(envPath, m.vars)
+		})
 		return saveMsg{err: err}
 	}
 }
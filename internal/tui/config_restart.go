@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -96,10 +97,13 @@ func (m *configRestartModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 
 func (m *configRestartModel) restartServices() tea.Cmd {
 	return func() tea.Msg {
-		args := stackctl.ComposeBaseArgs(m.cfg)
-		args = append(args, "restart")
-		args = append(args, m.services...)
-		_, err := stackctl.RunCmdCapture("docker", args...)
+		err := withEnvLock(m.cfg, "stackctl config (restart "+strings.Join(m.services, " ")+")", func(ctx context.Context) error {
+			args := stackctl.ComposeBaseArgs(m.cfg)
+			args = append(args, "restart")
+			args = append(args, m.services...)
+			_, err := stackctl.RunCmdCapture(ctx, "docker", args...)
+			return err
+		})
 		return restartDoneMsg{err: err}
 	}
 }
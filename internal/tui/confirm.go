@@ -74,7 +74,7 @@ func (m *confirmModel) View() string {
 	b.WriteString("\n")
 	b.WriteString(subtitleStyle.Render("  Equivalent CLI Commands"))
 	b.WriteString("\n")
-	b.WriteString(mutedStyle.Render(fmt.Sprintf("  $ stackctl init --env %s --domain %s --email %s",
+	b.WriteString(mutedStyle.Render(fmt.Sprintf("  $ stackctl init --env %s --domain %s --email %s --preset minimal",
 		m.state.env, m.state.domain, m.state.email)))
 	b.WriteString("\n")
 	for _, mod := range m.state.modules {
@@ -1,12 +1,15 @@
 package tui
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/example/stackctl/internal/engine"
 	"github.com/example/stackctl/internal/stackctl"
 )
 
@@ -30,7 +33,11 @@ type containerInfo struct {
 type envStatus struct {
 	Name       string
 	Containers []containerInfo
+	Ports      []string
 	Status     string // OK, DEGRADED, NOT DEPLOYED
+	// Lock describes the stackctl run holding the environment's lock, if
+	// any.
+	Lock string
 }
 
 type refreshMsg struct {
@@ -101,78 +108,55 @@ func (m dashModel) fetchAll() tea.Cmd {
 					}
 				}
 			}
-			statuses = append(statuses, envStatus{
+			es := envStatus{
 				Name:       env,
 				Containers: containers,
+				Ports:      stackctl.PortLines(cfg),
 				Status:     status,
-			})
+			}
+			if holder, locked := stackctl.EnvLockHolder(cfg); locked {
+				es.Lock = holder.String()
+			}
+			statuses = append(statuses, es)
 		}
 		return refreshMsg{envStatuses: statuses}
 	}
 }
 
-type composePS struct {
-	Service string `json:"Service"`
-	State   string `json:"State"`
-	Health  string `json:"Health"`
-	Ports   string `json:"Ports"`
-}
-
+// fetchContainers reads the environment's running containers and their
+// stats from the Engine API. Stats take about a second per container, so
+// they are sampled side by side.
 func fetchContainers(cfg stackctl.EnvConfig) []containerInfo {
-	args := stackctl.ComposeBaseArgs(cfg)
-	args = append(args, "ps", "--format", "json")
-	out, err := stackctl.RunCmdCapture("docker", args...)
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	client, err := stackctl.EngineClient(ctx, cfg)
 	if err != nil {
 		return nil
 	}
-
-	var containers []containerInfo
-	// docker compose ps --format json outputs one JSON object per line
-	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		var ps composePS
-		if err := json.Unmarshal([]byte(line), &ps); err != nil {
-			continue
-		}
-		containers = append(containers, containerInfo{
-			Service: ps.Service,
-			State:   ps.State,
-			Health:  ps.Health,
-			Ports:   ps.Ports,
-		})
+	list, err := client.ListContainers(ctx, engine.ListOptions{Project: cfg.EnvName})
+	if err != nil {
+		return nil
 	}
 
-	// Fetch stats
-	statsArgs := stackctl.ComposeBaseArgs(cfg)
-	statsArgs = append(statsArgs, "stats", "--no-stream", "--format", "json")
-	statsOut, err := stackctl.RunCmdCapture("docker", statsArgs...)
-	if err == nil {
-		type dockerStats struct {
-			Name    string `json:"Name"`
-			CPUPerc string `json:"CPUPerc"`
-			MemPerc string `json:"MemPerc"`
+	containers := make([]containerInfo, len(list))
+	var wg sync.WaitGroup
+	for i, c := range list {
+		containers[i] = containerInfo{
+			Service: c.Service(),
+			State:   c.State,
+			Health:  c.Health(),
+			Ports:   c.PortsString(),
 		}
-		for _, line := range strings.Split(strings.TrimSpace(statsOut), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if stats, err := client.ContainerStats(ctx, c.ID); err == nil {
+				containers[i].CPU = fmt.Sprintf("%.2f%%", stats.CPUPercent)
+				containers[i].Mem = fmt.Sprintf("%.2f%%", stats.MemPercent)
 			}
-			var ds dockerStats
-			if err := json.Unmarshal([]byte(line), &ds); err != nil {
-				continue
-			}
-			for i := range containers {
-				if strings.Contains(ds.Name, containers[i].Service) {
-					containers[i].CPU = ds.CPUPerc
-					containers[i].Mem = ds.MemPerc
-				}
-			}
-		}
+		}()
 	}
-
+	wg.Wait()
 	return containers
 }
 
@@ -308,22 +292,24 @@ func (m dashModel) restartService(env, service string) tea.Cmd {
 		if err != nil {
 			return restartDoneMsg{err: err}
 		}
-		args := stackctl.ComposeBaseArgs(cfg)
-		args = append(args, "restart", service)
-		_, err = stackctl.RunCmdCapture("docker", args...)
+		err = withEnvLock(cfg, "stackctl dash (restart "+service+")", func(ctx context.Context) error {
+			args := stackctl.ComposeBaseArgs(cfg)
+			args = append(args, "restart", service)
+			_, err := stackctl.RunCmdCapture(ctx, "docker", args...)
+			return err
+		})
 		return restartDoneMsg{err: err}
 	}
 }
 
+// execLogs follows the service's log with stackctl logs, which reads it from
+// the Engine API.
 func (m dashModel) execLogs(env, service string) tea.Cmd {
-	cfg, err := stackctl.LoadEnvConfig(env)
+	self, err := os.Executable()
 	if err != nil {
 		return nil
 	}
-	args := stackctl.ComposeBaseArgs(cfg)
-	args = append(args, "logs", "-f", service)
-	allArgs := append([]string{}, args...)
-	c := tea.ExecProcess(execCmd("docker", allArgs...), func(err error) tea.Msg {
+	c := tea.ExecProcess(execCmd(self, "logs", service, "--env", env, "-f"), func(err error) tea.Msg {
 		return restartDoneMsg{err: err}
 	})
 	return c
@@ -385,10 +371,17 @@ func (m dashModel) viewOverview() string {
 		return b.String()
 	}
 
+	nameWidth := 12
+	for _, es := range m.envStatuses {
+		if len(es.Name)+2 > nameWidth {
+			nameWidth = len(es.Name) + 2
+		}
+	}
+
 	// Header
-	b.WriteString(fmt.Sprintf("  %s%-12s %-14s %-8s%s\n",
+	b.WriteString(fmt.Sprintf("  %s%-*s %-14s %-8s%s\n",
 		"  ",
-		tableHeaderStyle.Render("ENV"),
+		nameWidth, tableHeaderStyle.Render("ENV"),
 		tableHeaderStyle.Render("CONTAINERS"),
 		tableHeaderStyle.Render("STATUS"),
 		""))
@@ -407,11 +400,16 @@ func (m dashModel) viewOverview() string {
 			statusStyle = statusStopped
 		}
 
-		b.WriteString(fmt.Sprintf("  %s %-12s %-14s %s\n",
+		lock := ""
+		if es.Lock != "" {
+			lock = "  " + warningStyle.Render("locked")
+		}
+		b.WriteString(fmt.Sprintf("  %s %-*s %-14s %s%s\n",
 			prefix,
-			normalStyle.Render(es.Name),
+			nameWidth, normalStyle.Render(es.Name),
 			mutedStyle.Render(fmt.Sprintf("%d", len(es.Containers))),
-			statusStyle.Render(es.Status)))
+			statusStyle.Render(es.Status),
+			lock))
 	}
 	return b.String()
 }
@@ -426,7 +424,12 @@ func (m dashModel) viewEnv() string {
 	}
 
 	b.WriteString(subtitleStyle.Render(fmt.Sprintf("  %s — Containers", es.Name)))
-	b.WriteString("\n\n")
+	b.WriteString("\n")
+	if es.Lock != "" {
+		b.WriteString(warningStyle.Render("  Locked by " + es.Lock))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
 
 	if len(es.Containers) == 0 {
 		b.WriteString(mutedStyle.Render("  No containers running."))
@@ -480,6 +483,15 @@ func (m dashModel) viewEnv() string {
 			mutedStyle.Render(mem),
 			mutedStyle.Render(ports)))
 	}
+
+	if len(es.Ports) > 0 {
+		b.WriteString("\n")
+		b.WriteString(subtitleStyle.Render("  Allocated ports"))
+		b.WriteString("\n")
+		for _, line := range es.Ports {
+			b.WriteString(fmt.Sprintf("     %s\n", mutedStyle.Render(line)))
+		}
+	}
 	return b.String()
 }
 
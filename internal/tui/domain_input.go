@@ -34,12 +34,10 @@ func (m *domainInputModel) Init() tea.Cmd {
 	} else {
 		// Smart default based on environment
 		switch m.state.env {
-		case "dev":
-			m.input.SetValue("dev.example.com")
-		case "qa":
-			m.input.SetValue("qa.example.com")
-		default:
+		case "prod", "":
 			m.input.SetValue("example.com")
+		default:
+			m.input.SetValue(strings.ReplaceAll(m.state.env, "_", "-") + ".example.com")
 		}
 	}
 	m.input.Focus()
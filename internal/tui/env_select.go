@@ -3,8 +3,10 @@ package tui
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/example/stackctl/internal/stackctl"
 )
@@ -14,26 +16,62 @@ type envOption struct {
 	label  string
 	desc   string
 	exists bool
+	custom bool
 }
 
 type envSelectModel struct {
 	state   *wizardState
 	cursor  int
 	options []envOption
+	typing  bool
+	input   textinput.Model
+	errMsg  string
+}
+
+var envDescriptions = map[string]string{
+	"dev":  "Development environment",
+	"qa":   "QA / staging environment",
+	"prod": "Production environment",
 }
 
 func newEnvSelectModel(state *wizardState) *envSelectModel {
+	ti := textinput.New()
+	ti.Placeholder = "staging"
+	ti.CharLimit = 63
+	ti.Width = 40
+
 	return &envSelectModel{
 		state: state,
-		options: []envOption{
-			{value: "dev", label: "dev", desc: "Development environment"},
-			{value: "qa", label: "qa", desc: "QA / staging environment"},
-			{value: "prod", label: "prod", desc: "Production environment"},
-		},
+		input: ti,
+	}
+}
+
+func (m *envSelectModel) buildOptions() {
+	m.options = nil
+	names := stackctl.RegisteredEnvironments()
+	if m.state.env != "" && !slices.Contains(names, m.state.env) {
+		names = append(names, m.state.env)
+	}
+	for _, name := range names {
+		desc, ok := envDescriptions[name]
+		if !ok {
+			desc = "Registered environment"
+		}
+		m.options = append(m.options, envOption{value: name, label: name, desc: desc})
 	}
+	m.options = append(m.options, envOption{
+		label:  "other...",
+		desc:   "Create a new named environment (e.g. staging, perf, demo-acme)",
+		custom: true,
+	})
 }
 
 func (m *envSelectModel) Init() tea.Cmd {
+	m.buildOptions()
+	m.typing = false
+	m.errMsg = ""
+	m.input.Blur()
+
 	// Restore cursor position if going back
 	for i, opt := range m.options {
 		if opt.value == m.state.env {
@@ -44,6 +82,9 @@ func (m *envSelectModel) Init() tea.Cmd {
 	// Check which environments already exist
 	stackRoot := stackctl.GetStackRoot()
 	for i := range m.options {
+		if m.options[i].custom {
+			continue
+		}
 		envDir := filepath.Join(stackRoot, m.options[i].value)
 		m.options[i].exists = stackctl.DirExists(envDir)
 	}
@@ -51,6 +92,10 @@ func (m *envSelectModel) Init() tea.Cmd {
 }
 
 func (m *envSelectModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
+	if m.typing {
+		return m.updateTyping(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if isEsc(msg) {
@@ -64,17 +109,55 @@ func (m *envSelectModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 		}
 		if isEnter(msg) {
 			selected := m.options[m.cursor]
-			// Clear domain when env changes so smart defaults recalculate
-			if m.state.env != selected.value {
-				m.state.domain = ""
+			if selected.custom {
+				m.typing = true
+				m.errMsg = ""
+				m.input.SetValue("")
+				m.input.Focus()
+				return m, textinput.Blink
 			}
-			m.state.env = selected.value
-			return m, func() tea.Msg { return navigateMsg{to: screenDomainInput} }
+			return m, m.selectEnv(selected.value)
 		}
 	}
 	return m, nil
 }
 
+func (m *envSelectModel) updateTyping(msg tea.Msg) (screenModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if isEsc(msg) {
+			m.typing = false
+			m.errMsg = ""
+			m.input.Blur()
+			return m, nil
+		}
+		if isEnter(msg) {
+			name := strings.TrimSpace(m.input.Value())
+			if err := stackctl.ValidateEnvName(name); err != nil {
+				m.errMsg = err.Error()
+				return m, nil
+			}
+			m.typing = false
+			m.errMsg = ""
+			m.input.Blur()
+			return m, m.selectEnv(name)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m *envSelectModel) selectEnv(name string) tea.Cmd {
+	// Clear domain when env changes so smart defaults recalculate
+	if m.state.env != name {
+		m.state.domain = ""
+	}
+	m.state.env = name
+	return func() tea.Msg { return navigateMsg{to: screenDomainInput} }
+}
+
 func (m *envSelectModel) View() string {
 	var b strings.Builder
 
@@ -100,6 +183,17 @@ func (m *envSelectModel) View() string {
 		b.WriteString(fmt.Sprintf("      %s\n", mutedStyle.Render(opt.desc)))
 	}
 
+	if m.typing {
+		b.WriteString("\n  " + m.input.View())
+		b.WriteString("\n")
+		if m.errMsg != "" {
+			b.WriteString("\n  " + errorStyle.Render(m.errMsg))
+			b.WriteString("\n")
+		}
+		b.WriteString(helpStyle.Render("\n  enter: confirm name  esc: cancel"))
+		return b.String()
+	}
+
 	b.WriteString(helpStyle.Render("\n  up/down: navigate  enter: select  esc: back"))
 	return b.String()
 }
@@ -61,6 +61,7 @@ func (m *helpModel) View() string {
 			title: "Setup Wizard",
 			keys: []struct{ key, desc string }{
 				{"space", "Toggle module selection"},
+				{"p", "Cycle module presets"},
 				{"enter", "Confirm and proceed"},
 				{"esc", "Go back to previous step"},
 			},
@@ -71,6 +72,7 @@ func (m *helpModel) View() string {
 				{"space", "Toggle module enabled/disabled"},
 				{"/", "Search/filter modules"},
 				{"d", "Toggle detail pane"},
+				{"x", "Disable module, keeping, archiving or purging its data"},
 				{"s", "Save changes"},
 				{"a", "Save + apply"},
 				{"q", "Quit"},
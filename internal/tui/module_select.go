@@ -17,10 +17,15 @@ type moduleRow struct {
 
 type moduleSelectModel struct {
 	state    *wizardState
+	catalog  stackctl.Catalog
 	rows     []moduleRow
 	cursor   int
 	selected map[string]bool
 	depMsg   string
+	errMsg   string
+	presets  stackctl.Presets
+	// started is set once the default preset has been preselected.
+	started bool
 }
 
 func newModuleSelectModel(state *wizardState) *moduleSelectModel {
@@ -28,33 +33,106 @@ func newModuleSelectModel(state *wizardState) *moduleSelectModel {
 		state:    state,
 		selected: map[string]bool{},
 	}
-	m.buildRows()
+	catalog, err := stackctl.LoadCatalog()
+	if err != nil {
+		m.errMsg = fmt.Sprintf("Error loading modules: %v", err)
+	}
+	m.catalog = catalog
+	m.rows = buildModuleRows(catalog)
+	if presets, err := stackctl.LoadPresets(); err == nil {
+		m.presets = presets
+	} else if m.errMsg == "" {
+		m.errMsg = fmt.Sprintf("Error loading presets: %v", err)
+	}
 	return m
 }
 
-func (m *moduleSelectModel) buildRows() {
-	categories := []string{"Observability", "Infrastructure", "Utilities"}
-	grouped := map[string][]string{}
-	for name, info := range stackctl.ModuleCatalog {
-		grouped[info.Category] = append(grouped[info.Category], name)
+func buildModuleRows(catalog stackctl.Catalog) []moduleRow {
+	grouped := catalog.ByCategory()
+
+	var rows []moduleRow
+	for _, cat := range catalog.Categories() {
+		rows = append(rows, moduleRow{isCategory: true, category: cat})
+		for _, name := range grouped[cat] {
+			rows = append(rows, moduleRow{name: name})
+		}
+	}
+	return rows
+}
+
+// toggleModuleSet flips name in set using the catalog resolver: enabling
+// pulls in transitive dependencies and is refused on conflicts, disabling is
+// refused while another selected module depends on name. It returns a status
+// message for the user, if any.
+func toggleModuleSet(catalog stackctl.Catalog, set map[string]bool, name string) string {
+	selected := make([]string, 0, len(set))
+	for mod := range set {
+		selected = append(selected, mod)
 	}
 
-	m.rows = nil
-	for _, cat := range categories {
-		names := grouped[cat]
-		if len(names) == 0 {
-			continue
+	if set[name] {
+		if dependents := catalog.EnabledDependents(name, selected); len(dependents) > 0 {
+			return fmt.Sprintf("cannot disable %s: required by %s", name, strings.Join(dependents, ", "))
 		}
-		sort.Strings(names)
-		m.rows = append(m.rows, moduleRow{isCategory: true, category: cat})
-		for _, name := range names {
-			m.rows = append(m.rows, moduleRow{name: name})
+		delete(set, name)
+		return ""
+	}
+
+	res, err := catalog.Resolve(append(selected, name))
+	if err != nil {
+		return fmt.Sprintf("cannot enable %s: %v", name, err)
+	}
+	var msgs []string
+	for _, mod := range res.Order {
+		if !set[mod] && mod != name {
+			msgs = append(msgs, fmt.Sprintf("auto-enabled %s (required by %s)", mod, res.Added[mod]))
 		}
+		set[mod] = true
+	}
+	if len(res.Recommended) > 0 {
+		msgs = append(msgs, "recommended: "+strings.Join(res.Recommended, ", "))
 	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m *moduleSelectModel) applyPreset(name string) {
+	modules, err := m.presets.Modules(m.catalog, name)
+	if err != nil {
+		m.errMsg = err.Error()
+		return
+	}
+	m.selected = map[string]bool{}
+	for _, mod := range modules {
+		m.selected[mod] = true
+	}
+	if name == "" {
+		name = m.presets.Default
+	}
+	m.state.preset = name
+	m.depMsg = fmt.Sprintf("preset %s: %s", name, m.presets.Presets[name].Description)
+}
+
+// nextPreset cycles through the presets in name order.
+func (m *moduleSelectModel) nextPreset() {
+	names := m.presets.Names()
+	if len(names) == 0 {
+		return
+	}
+	next := names[0]
+	for i, name := range names {
+		if name == m.state.preset && i+1 < len(names) {
+			next = names[i+1]
+		}
+	}
+	m.applyPreset(next)
 }
 
 func (m *moduleSelectModel) Init() tea.Cmd {
-	// Restore selections from state
+	// Restore selections from state, or start from the default preset
+	if !m.started && len(m.state.modules) == 0 {
+		m.applyPreset(m.presets.Default)
+	}
+	m.started = true
 	for _, mod := range m.state.modules {
 		m.selected[mod] = true
 	}
@@ -98,26 +176,15 @@ func (m *moduleSelectModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 				}
 			}
 		}
-		if isSpace(msg) {
+		if isSpace(msg) && m.cursor >= 0 && m.cursor < len(m.rows) {
 			row := m.rows[m.cursor]
 			if !row.isCategory {
-				m.depMsg = ""
-				if m.selected[row.name] {
-					delete(m.selected, row.name)
-				} else {
-					m.selected[row.name] = true
-					// Auto-resolve dependencies
-					if deps, ok := stackctl.ModuleDependencies[row.name]; ok {
-						for _, dep := range deps {
-							if !m.selected[dep] {
-								m.selected[dep] = true
-								m.depMsg = fmt.Sprintf("auto-enabled %s (required by %s)", dep, row.name)
-							}
-						}
-					}
-				}
+				m.depMsg = toggleModuleSet(m.catalog, m.selected, row.name)
 			}
 		}
+		if msg.String() == "p" {
+			m.nextPreset()
+		}
 		if isEnter(msg) {
 			m.state.modules = nil
 			for name := range m.selected {
@@ -145,7 +212,7 @@ func (m *moduleSelectModel) View() string {
 			continue
 		}
 
-		info := stackctl.ModuleCatalog[row.name]
+		info := m.catalog[row.name]
 		check := checkOff
 		if m.selected[row.name] {
 			check = checkOn
@@ -160,7 +227,7 @@ func (m *moduleSelectModel) View() string {
 
 		ports := "-"
 		if len(info.Ports) > 0 {
-			ports = strings.Join(info.Ports, ", ")
+			ports = strings.Join(info.DefaultPorts(), ", ")
 		}
 
 		b.WriteString(fmt.Sprintf("  %s %s %s  %s",
@@ -170,10 +237,14 @@ func (m *moduleSelectModel) View() string {
 		b.WriteString(fmt.Sprintf("          %s\n", mutedStyle.Render("ports: "+ports)))
 	}
 
+	if m.errMsg != "" {
+		b.WriteString("\n  " + errorStyle.Render(m.errMsg))
+	}
+
 	if m.depMsg != "" {
 		b.WriteString("\n  " + warningStyle.Render(m.depMsg))
 	}
 
-	b.WriteString(helpStyle.Render("\n  up/down: navigate  space: toggle  enter: confirm  esc: back"))
+	b.WriteString(helpStyle.Render("\n  up/down: navigate  space: toggle  p: next preset  enter: confirm  esc: back"))
 	return b.String()
 }
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -10,6 +11,7 @@ import (
 type modulesDetailModel struct {
 	module  string
 	cfg     stackctl.EnvConfig
+	catalog stackctl.Catalog
 	enabled map[string]bool
 }
 
@@ -22,7 +24,7 @@ func (m *modulesDetailModel) View() string {
 		return ""
 	}
 
-	info, ok := stackctl.ModuleCatalog[m.module]
+	info, ok := m.catalog[m.module]
 	if !ok {
 		return ""
 	}
@@ -33,35 +35,53 @@ func (m *modulesDetailModel) View() string {
 	b.WriteString("\n")
 	b.WriteString(fmt.Sprintf("  %s\n", normalStyle.Render(info.Description)))
 	b.WriteString(fmt.Sprintf("  Category: %s\n", mutedStyle.Render(info.Category)))
+	b.WriteString(fmt.Sprintf("  Source:   %s\n", mutedStyle.Render(info.Dir)))
 
-	// Ports
+	// Ports: the allocation for this environment once applied, the module
+	// defaults otherwise.
 	if len(info.Ports) > 0 {
-		b.WriteString(fmt.Sprintf("  Ports:    %s\n", mutedStyle.Render(strings.Join(info.Ports, ", "))))
+		st, _ := stackctl.LoadState(m.cfg)
+		allocated := st.Ports[m.module]
+		var ports []string
+		for _, p := range info.Ports {
+			if port, ok := allocated[p.Name]; ok {
+				ports = append(ports, fmt.Sprintf("%s %s:%d", p.Name, stackctl.LoopbackHost, port))
+			} else {
+				ports = append(ports, fmt.Sprintf("%s %s (default)", p.Name, p.String()))
+			}
+		}
+		b.WriteString(fmt.Sprintf("  Ports:    %s\n", mutedStyle.Render(strings.Join(ports, ", "))))
 	} else {
 		b.WriteString(fmt.Sprintf("  Ports:    %s\n", mutedStyle.Render("none")))
 	}
 
+	// Params: the values set for this environment, defaults otherwise.
+	if len(info.Params) > 0 {
+		enabled, _ := stackctl.LoadEnabled(m.cfg)
+		var params []string
+		for _, p := range info.Params {
+			if v, ok := enabled.Params[m.module][p.Name]; ok {
+				params = append(params, fmt.Sprintf("%s=%s", p.Name, v))
+			} else {
+				params = append(params, fmt.Sprintf("%s=%s (default)", p.Name, p.Default))
+			}
+		}
+		b.WriteString(fmt.Sprintf("  Params:   %s\n", mutedStyle.Render(strings.Join(params, ", "))))
+	}
+
 	// Dependencies
-	if deps, ok := stackctl.ModuleDependencies[m.module]; ok && len(deps) > 0 {
-		b.WriteString(fmt.Sprintf("  Depends:  %s\n", mutedStyle.Render(strings.Join(deps, ", "))))
+	if len(info.Depends) > 0 {
+		b.WriteString(fmt.Sprintf("  Depends:  %s\n", mutedStyle.Render(strings.Join(info.Depends, ", "))))
 	}
 
 	// Reverse dependencies
-	var rdeps []string
-	for mod, deps := range stackctl.ModuleDependencies {
-		for _, dep := range deps {
-			if dep == m.module {
-				rdeps = append(rdeps, mod)
-			}
-		}
-	}
-	if len(rdeps) > 0 {
+	if rdeps := m.catalog.Dependents(m.module); len(rdeps) > 0 {
 		b.WriteString(fmt.Sprintf("  Needed by: %s\n", mutedStyle.Render(strings.Join(rdeps, ", "))))
 	}
 
 	// Status
 	if m.enabled[m.module] {
-		if stackctl.ComposeServiceRunning(m.cfg, m.module) {
+		if stackctl.ComposeServiceRunning(context.Background(), m.cfg, m.module) {
 			b.WriteString(fmt.Sprintf("  Status:   %s\n", statusRunning.Render("running")))
 		} else {
 			b.WriteString(fmt.Sprintf("  Status:   %s\n", statusStopped.Render("stopped")))
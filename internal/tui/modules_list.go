@@ -1,7 +1,9 @@
 package tui
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"sort"
 	"strings"
 
@@ -12,6 +14,7 @@ import (
 
 type modulesListModel struct {
 	cfg             stackctl.EnvConfig
+	catalog         stackctl.Catalog
 	rows            []moduleRow
 	cursor          int
 	enabled         map[string]bool
@@ -24,6 +27,10 @@ type modulesListModel struct {
 	detailModel     *modulesDetailModel
 	showQuitWarning bool
 	statusMsg       string
+	// removing is the module picked with x; removeStep is 1 while choosing
+	// how to disable it and 2 while confirming a purge.
+	removing   string
+	removeStep int
 }
 
 func newModulesListModel(cfg stackctl.EnvConfig) *modulesListModel {
@@ -37,37 +44,25 @@ func newModulesListModel(cfg stackctl.EnvConfig) *modulesListModel {
 		enabled:     map[string]bool{},
 		searchInput: ti,
 	}
-	m.buildRows()
+	catalog, err := stackctl.LoadCatalog()
+	if err != nil {
+		m.statusMsg = fmt.Sprintf("Error loading modules: %v", err)
+	}
+	m.catalog = catalog
+	m.rows = buildModuleRows(catalog)
 	m.detailModel = newModulesDetailModel()
 	return m
 }
 
-func (m *modulesListModel) buildRows() {
-	categories := []string{"Observability", "Infrastructure", "Utilities"}
-	grouped := map[string][]string{}
-	for name, info := range stackctl.ModuleCatalog {
-		grouped[info.Category] = append(grouped[info.Category], name)
-	}
-
-	m.rows = nil
-	for _, cat := range categories {
-		names := grouped[cat]
-		if len(names) == 0 {
-			continue
-		}
-		sort.Strings(names)
-		m.rows = append(m.rows, moduleRow{isCategory: true, category: cat})
-		for _, name := range names {
-			m.rows = append(m.rows, moduleRow{name: name})
-		}
-	}
-}
-
 func (m *modulesListModel) Init() tea.Cmd {
-	// Load current enabled modules
+	// Load current enabled modules, including the dependencies they pull in
 	enabled, err := stackctl.LoadEnabled(m.cfg)
 	if err == nil {
-		for _, mod := range enabled.Modules {
+		modules := enabled.Modules
+		if res, err := m.catalog.Resolve(modules); err == nil {
+			modules = res.Modules
+		}
+		for _, mod := range modules {
 			m.enabled[mod] = true
 		}
 	}
@@ -94,7 +89,7 @@ func (m *modulesListModel) updateFilter() {
 			m.filteredRows = append(m.filteredRows, i)
 			continue
 		}
-		info := stackctl.ModuleCatalog[row.name]
+		info := m.catalog[row.name]
 		if strings.Contains(strings.ToLower(row.name), filter) ||
 			strings.Contains(strings.ToLower(info.Description), filter) {
 			m.filteredRows = append(m.filteredRows, i)
@@ -154,7 +149,28 @@ func (m *modulesListModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 		}
 		return m, nil
 
+	case removeMsg:
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("Disable error: %v", msg.err)
+			return m, nil
+		}
+		for _, name := range msg.disabled {
+			delete(m.enabled, name)
+		}
+		verb := "Disabled"
+		switch msg.mode {
+		case stackctl.DisableArchive:
+			verb = "Archived data and disabled"
+		case stackctl.DisablePurge:
+			verb = "Purged data and disabled"
+		}
+		m.statusMsg = fmt.Sprintf("%s: %s (apply to stop remaining containers)", verb, strings.Join(msg.disabled, ", "))
+		return m, nil
+
 	case tea.KeyMsg:
+		if m.removeStep > 0 {
+			return m.updateRemove(msg)
+		}
 		if m.showQuitWarning {
 			switch msg.String() {
 			case "y", "Y":
@@ -174,6 +190,8 @@ func (m *modulesListModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 			m.toggleModule()
 		case msg.String() == "d":
 			m.showDetail = !m.showDetail
+		case msg.String() == "x":
+			m.startRemove()
 		case isSlash(msg):
 			m.searching = true
 			m.searchInput.Focus()
@@ -194,12 +212,89 @@ func (m *modulesListModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 	if m.showDetail && m.cursor < len(m.rows) && !m.rows[m.cursor].isCategory {
 		m.detailModel.module = m.rows[m.cursor].name
 		m.detailModel.cfg = m.cfg
+		m.detailModel.catalog = m.catalog
 		m.detailModel.enabled = m.enabled
 	}
 
 	return m, nil
 }
 
+func (m *modulesListModel) startRemove() {
+	if m.cursor >= len(m.rows) || m.rows[m.cursor].isCategory {
+		return
+	}
+	name := m.rows[m.cursor].name
+	if m.dirty {
+		m.statusMsg = "Save changes before removing a module"
+		return
+	}
+	if dependents := m.catalog.EnabledDependents(name, enabledList(m.enabled)); len(dependents) > 0 {
+		m.statusMsg = fmt.Sprintf("cannot disable %s: required by %s", name, strings.Join(dependents, ", "))
+		return
+	}
+	m.removing = name
+	m.removeStep = 1
+	m.statusMsg = ""
+}
+
+func (m *modulesListModel) updateRemove(msg tea.KeyMsg) (screenModel, tea.Cmd) {
+	if m.removeStep == 2 {
+		m.removeStep = 0
+		if msg.String() == "y" || msg.String() == "Y" {
+			return m, m.remove(stackctl.DisablePurge)
+		}
+		m.statusMsg = "Purge cancelled"
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "k":
+		m.removeStep = 0
+		return m, m.remove(stackctl.DisableKeepData)
+	case "a":
+		m.removeStep = 0
+		return m, m.remove(stackctl.DisableArchive)
+	case "p":
+		m.removeStep = 2
+		return m, nil
+	}
+	m.removeStep = 0
+	return m, nil
+}
+
+func (m *modulesListModel) remove(mode stackctl.DisableMode) tea.Cmd {
+	name := m.removing
+	return func() tea.Msg {
+		var disabled []string
+		err := withEnvLock(m.cfg, "stackctl modules (remove "+name+")", func(ctx context.Context) error {
+			cfg := m.cfg
+			if err := stackctl.HydrateFromDotEnv(&cfg); err != nil {
+				return err
+			}
+			var err error
+			disabled, err = stackctl.DisableModule(ctx, cfg, m.catalog, name, stackctl.DisableOptions{
+				Mode: mode,
+				// The purge was confirmed in the TUI already.
+				Confirm: func(modules, paths []string) bool { return true },
+			})
+			return err
+		})
+		if err == nil && len(disabled) == 0 {
+			disabled = []string{name}
+		}
+		return removeMsg{mode: mode, disabled: disabled, err: err}
+	}
+}
+
+func enabledList(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for name := range set {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
 func (m *modulesListModel) updateSearch(msg tea.Msg) (screenModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -229,25 +324,20 @@ func (m *modulesListModel) toggleModule() {
 		return
 	}
 	name := m.rows[m.cursor].name
-	if m.enabled[name] {
-		delete(m.enabled, name)
-	} else {
-		m.enabled[name] = true
-		// Auto-resolve dependencies
-		if deps, ok := stackctl.ModuleDependencies[name]; ok {
-			for _, dep := range deps {
-				if !m.enabled[dep] {
-					m.enabled[dep] = true
-					m.statusMsg = fmt.Sprintf("auto-enabled %s (required by %s)", dep, name)
-				}
-			}
-		}
+	was := m.enabled[name]
+	m.statusMsg = toggleModuleSet(m.catalog, m.enabled, name)
+	if m.enabled[name] != was {
+		m.dirty = true
 	}
-	m.dirty = true
 }
 
 type saveMsg struct{ err error }
 type applyMsg struct{ err error }
+type removeMsg struct {
+	mode     stackctl.DisableMode
+	disabled []string
+	err      error
+}
 
 func (m *modulesListModel) save() tea.Cmd {
 	return func() tea.Msg {
@@ -256,15 +346,32 @@ func (m *modulesListModel) save() tea.Cmd {
 			modules = append(modules, name)
 		}
 		sort.Strings(modules)
-		conf := stackctl.EnabledConfig{Modules: modules}
-		err := stackctl.WriteEnabled(m.cfg, conf)
+		err := withEnvLock(m.cfg, "stackctl modules (save)", func(ctx context.Context) error {
+			// The disable hooks render {{.Domain}} and get STACKCTL_DOMAIN.
+			cfg := m.cfg
+			if err := stackctl.HydrateFromDotEnv(&cfg); err != nil {
+				return err
+			}
+			// Keep the params of modules that stay enabled.
+			conf, _ := stackctl.LoadEnabled(cfg)
+			params := maps.Clone(conf.Params)
+			removed := stackctl.RemovedModules(m.catalog, conf.Modules, modules)
+			if err := stackctl.RunModuleHooks(ctx, cfg, stackctl.HookPreDisable, removed, params); err != nil {
+				return err
+			}
+			conf.SetModules(modules)
+			if err := stackctl.WriteEnabled(cfg, conf); err != nil {
+				return err
+			}
+			return stackctl.RunModuleHooks(ctx, cfg, stackctl.HookPostDisable, removed, params)
+		})
 		return saveMsg{err: err}
 	}
 }
 
 func (m *modulesListModel) apply() tea.Cmd {
 	return func() tea.Msg {
-		err := stackctl.Run([]string{"apply", "--env", m.cfg.EnvName})
+		err := stackctl.Run(quietContext(), []string{"apply", "--env", m.cfg.EnvName})
 		return applyMsg{err: err}
 	}
 }
@@ -296,7 +403,7 @@ func (m *modulesListModel) View() string {
 			continue
 		}
 
-		info := stackctl.ModuleCatalog[row.name]
+		info := m.catalog[row.name]
 		check := checkOff
 		if m.enabled[row.name] {
 			check = checkOn
@@ -312,7 +419,7 @@ func (m *modulesListModel) View() string {
 		// Running status
 		status := ""
 		if m.enabled[row.name] {
-			if stackctl.ComposeServiceRunning(m.cfg, row.name) {
+			if stackctl.ComposeServiceRunning(context.Background(), m.cfg, row.name) {
 				status = statusRunning.Render(" [running]")
 			} else {
 				status = statusStopped.Render(" [stopped]")
@@ -334,12 +441,21 @@ func (m *modulesListModel) View() string {
 		b.WriteString("\n")
 	}
 
+	switch m.removeStep {
+	case 1:
+		b.WriteString("\n  " + warningStyle.Render(fmt.Sprintf("Disable %s: k: keep data  a: archive data to backups  p: purge data  any other key: cancel", m.removing)))
+		b.WriteString("\n")
+	case 2:
+		b.WriteString("\n  " + errorStyle.Render(fmt.Sprintf("Permanently delete all data of %s in %s? Press 'y' to purge or any key to cancel.", m.removing, m.cfg.EnvName)))
+		b.WriteString("\n")
+	}
+
 	// Detail pane
 	if m.showDetail && m.cursor < len(m.rows) && !m.rows[m.cursor].isCategory {
 		b.WriteString("\n")
 		b.WriteString(m.detailModel.View())
 	}
 
-	b.WriteString(helpStyle.Render("\n  j/k: navigate  space: toggle  /: search  d: detail  s: save  a: apply  q: quit"))
+	b.WriteString(helpStyle.Render("\n  j/k: navigate  space: toggle  /: search  d: detail  x: disable/archive/purge  s: save  a: apply  q: quit"))
 	return b.String()
 }
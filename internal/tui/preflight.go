@@ -41,7 +41,7 @@ func (m *preflightModel) Init() tea.Cmd {
 
 func (m *preflightModel) runChecks() tea.Cmd {
 	return func() tea.Msg {
-		results := stackctl.RunChecks()
+		results := stackctl.RunChecks(quietContext())
 		return checksDoneMsg{results: results}
 	}
 }
@@ -1,11 +1,8 @@
 package tui
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"os"
-	"sort"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -33,9 +30,17 @@ type stepDoneMsg struct {
 	err   error
 }
 
+// healthMsg carries the service states polled while the apply step waits
+// for the services to come up.
+type healthMsg struct {
+	states []stackctl.ServiceHealth
+}
+
 type progressModel struct {
 	state     *wizardState
 	steps     []progressStep
+	health    []stackctl.ServiceHealth
+	healthCh  chan []stackctl.ServiceHealth
 	spinner   spinner.Model
 	current   int
 	done      bool
@@ -76,6 +81,17 @@ func (m *progressModel) Init() tea.Cmd {
 }
 
 func (m *progressModel) runStep(index int) tea.Cmd {
+	if index == 2 {
+		m.health = nil
+		ch := make(chan []stackctl.ServiceHealth)
+		m.healthCh = ch
+		apply := func() tea.Msg {
+			err := m.doApply(ch)
+			close(ch)
+			return stepDoneMsg{index: index, err: err}
+		}
+		return tea.Batch(apply, waitForHealth(ch))
+	}
 	return func() tea.Msg {
 		var err error
 		switch index {
@@ -83,23 +99,39 @@ func (m *progressModel) runStep(index int) tea.Cmd {
 			err = m.doInit()
 		case 1:
 			err = m.doEnable()
-		case 2:
-			err = m.doApply()
 		}
 		return stepDoneMsg{index: index, err: err}
 	}
 }
 
-func captureOutput(fn func() error) (string, error) {
-	oldOut, oldErr := os.Stdout, os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stdout, os.Stderr = w, w
-	err := fn()
-	w.Close()
-	os.Stdout, os.Stderr = oldOut, oldErr
-	var buf bytes.Buffer
-	io.Copy(&buf, r)
-	return buf.String(), err
+func waitForHealth(ch <-chan []stackctl.ServiceHealth) tea.Cmd {
+	return func() tea.Msg {
+		states, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return healthMsg{states: states}
+	}
+}
+
+// quietContext returns a context whose runner captures what commands and
+// stackctl print, which would otherwise draw over the TUI.
+func quietContext() context.Context {
+	return stackctl.WithRunner(context.Background(), stackctl.NewCaptureRunner())
+}
+
+// withEnvLock runs fn holding the environment's lock, failing right away if
+// another stackctl run holds it, and records it in the history. fn gets a
+// quietContext.
+func withEnvLock(cfg stackctl.EnvConfig, command string, fn func(ctx context.Context) error) error {
+	ctx := quietContext()
+	run, err := stackctl.StartEnvRun(ctx, cfg, command, 0)
+	if err != nil {
+		return err
+	}
+	err = fn(ctx)
+	run.Finish(ctx, err)
+	return err
 }
 
 func (m *progressModel) doInit() error {
@@ -110,36 +142,48 @@ func (m *progressModel) doInit() error {
 	cfg.Domain = m.state.domain
 	cfg.Email = m.state.email
 
-	_, err = captureOutput(func() error {
-		return stackctl.RunInit(cfg)
+	return withEnvLock(cfg, "stackctl setup (init)", func(ctx context.Context) error {
+		return stackctl.RunInit(ctx, cfg, m.state.preset)
 	})
-	return err
 }
 
 func (m *progressModel) doEnable() error {
-	if len(m.state.modules) == 0 {
-		return nil
-	}
-
 	cfg, err := stackctl.LoadEnvConfig(m.state.env)
 	if err != nil {
 		return err
 	}
 
-	modules := make([]string, len(m.state.modules))
-	copy(modules, m.state.modules)
-	modules = stackctl.AddModuleDependencies(modules)
-	sort.Strings(modules)
+	catalog, err := stackctl.LoadCatalog()
+	if err != nil {
+		return err
+	}
+	res, err := catalog.Resolve(m.state.modules)
+	if err != nil {
+		return err
+	}
+	modules := res.Modules
 
-	conf := stackctl.EnabledConfig{Modules: modules}
-	return stackctl.WriteEnabled(cfg, conf)
+	return withEnvLock(cfg, "stackctl setup (enable)", func(ctx context.Context) error {
+		conf, _ := stackctl.LoadEnabled(cfg)
+		conf.SetModules(modules)
+		return stackctl.WriteEnabled(cfg, conf)
+	})
 }
 
-func (m *progressModel) doApply() error {
-	_, err := captureOutput(func() error {
-		return stackctl.Run([]string{"apply", "--env", m.state.env})
+func (m *progressModel) doApply(health chan<- []stackctl.ServiceHealth) error {
+	cfg, err := stackctl.LoadEnvConfig(m.state.env)
+	if err != nil {
+		return err
+	}
+	if err := stackctl.HydrateFromDotEnv(&cfg); err != nil {
+		return err
+	}
+	return withEnvLock(cfg, "stackctl setup (apply)", func(ctx context.Context) error {
+		return stackctl.Apply(ctx, cfg, stackctl.ApplyOptions{
+			WaitTimeout: stackctl.DefaultWaitTimeout,
+			Progress:    func(states []stackctl.ServiceHealth) { health <- states },
+		})
 	})
-	return err
 }
 
 func (m *progressModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
@@ -149,6 +193,10 @@ func (m *progressModel) Update(msg tea.Msg) (screenModel, tea.Cmd) {
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
 
+	case healthMsg:
+		m.health = msg.states
+		return m, waitForHealth(m.healthCh)
+
 	case stepDoneMsg:
 		m.steps[msg.index].status = stepDone
 		if msg.err != nil {
@@ -218,6 +266,18 @@ func (m *progressModel) View() string {
 		b.WriteString(fmt.Sprintf("  %s %s\n", icon, normalStyle.Render(step.label)))
 	}
 
+	// Per-service progress of the apply step's health wait.
+	for _, st := range m.health {
+		icon := mutedStyle.Render("..")
+		switch status := st.Status(); {
+		case st.Ready():
+			icon = successStyle.Render("OK")
+		case status == "unhealthy" || strings.HasPrefix(status, "exited") || status == "restarting":
+			icon = errorStyle.Render("!!")
+		}
+		b.WriteString(fmt.Sprintf("      %s %-24s %s\n", icon, st.Service, mutedStyle.Render(st.Status())))
+	}
+
 	if m.errMsg != "" {
 		b.WriteString("\n")
 		b.WriteString(errorStyle.Render("  Error: " + m.errMsg))
@@ -32,6 +32,7 @@ type wizardState struct {
 	domain  string
 	email   string
 	modules []string
+	preset  string
 }
 
 type screenModel interface {
@@ -110,6 +111,7 @@ func (m rootModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.state.domain = ""
 		m.state.email = ""
 		m.state.modules = nil
+		m.state.preset = ""
 		// Recreate module select to clear selections
 		m.screens[screenModuleSelect] = newModuleSelectModel(m.state)
 		m.current = screenEnvSelect
@@ -0,0 +1,10 @@
+#!/bin/sh
+# Grafana runs as uid 472 and must own its data directory.
+set -eu
+dir="$STACKCTL_DATA_DIR/grafana"
+mkdir -p "$dir"
+if [ "$(id -u)" -ne 0 ]; then
+  echo "not root: make sure $dir is owned by 472:0"
+  exit 0
+fi
+chown -R 472:0 "$dir"
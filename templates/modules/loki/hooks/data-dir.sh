@@ -0,0 +1,11 @@
+#!/bin/sh
+# Loki runs as uid 10001 and expects its chunk, rules and compactor
+# directories to exist and be writable.
+set -eu
+dir="$STACKCTL_DATA_DIR/loki"
+mkdir -p "$dir/chunks" "$dir/rules" "$dir/compactor" "$dir/tsdb-index" "$dir/tsdb-cache"
+if [ "$(id -u)" -ne 0 ]; then
+  echo "not root: make sure $dir is owned by 10001:10001"
+  exit 0
+fi
+chown -R 10001:10001 "$dir"